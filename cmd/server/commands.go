@@ -0,0 +1,213 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+	"wiretify/internal/config"
+	"wiretify/internal/database"
+	"wiretify/internal/secrets"
+	"wiretify/internal/services"
+)
+
+const usage = `Commands:
+  rotate-server-key [network]   generate a new server key for a network (default if omitted) and flag its peer configs
+  import-wg-quick [--network N] [--adopt-key] [--dry-run] <file>
+                                import peers from a wg-quick config such as /etc/wireguard/wg0.conf
+  export-wg-quick [network]     print the server-side wg-quick config (with PostUp/PostDown firewall rules)
+  export-snapshot               print a JSON snapshot of all configuration, including secrets
+  restore-snapshot <file>       replace all configuration with a snapshot (stop the service first)
+  rotate-master-key             re-encrypt stored secrets under a new master key (stop the service first)
+`
+
+// runCommand chạy các lệnh quản trị một lần, vd. `wiretify rotate-server-key`
+func runCommand(cfg *config.Config, networks *services.NetworkManager, args []string) {
+	switch args[0] {
+	case "rotate-server-key":
+		wn := networks.Default()
+		if len(args) > 1 {
+			wn = findNetwork(networks, args[1])
+			if wn == nil {
+				log.Fatalf("Network %q not found", args[1])
+			}
+		}
+		if wn.WG == nil {
+			log.Fatalf("WireGuard controller for %s is not available", wn.InterfaceName)
+		}
+		defer networks.Close()
+
+		pubKey, err := wn.WG.RotateServerKey()
+		if pubKey == "" {
+			log.Fatalf("Failed to rotate server key: %v", err)
+		}
+		if err != nil {
+			log.Printf("Warning: %v (the running server applies it on its next sync)", err)
+		}
+		fmt.Println(pubKey)
+	case "import-wg-quick":
+		importWGQuick(networks, args[1:])
+	case "export-wg-quick":
+		exportWGQuick(networks, args[1:])
+	case "export-snapshot":
+		snap, err := services.ExportSnapshot()
+		if err != nil {
+			log.Fatalf("Failed to export snapshot: %v", err)
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(snap); err != nil {
+			log.Fatalf("%v", err)
+		}
+	case "restore-snapshot":
+		restoreSnapshot(args[1:])
+	case "rotate-master-key":
+		rotateMasterKey(cfg)
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown command %q\n\n%s", args[0], usage)
+		os.Exit(2)
+	}
+}
+
+// importWGQuick import peer từ file wg-quick, in các peer đã tạo và các conflict
+func importWGQuick(networks *services.NetworkManager, args []string) {
+	fs := flag.NewFlagSet("import-wg-quick", flag.ExitOnError)
+	network := fs.String("network", "", "target network (ID or name, default network if empty)")
+	adoptKey := fs.Bool("adopt-key", false, "use the [Interface] PrivateKey as the server key")
+	dryRun := fs.Bool("dry-run", false, "only report what would be imported")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		log.Fatalf("Usage: import-wg-quick [--network N] [--adopt-key] [--dry-run] <file>")
+	}
+	defer networks.Close()
+
+	wn := networks.Default()
+	if *network != "" {
+		if wn = findNetwork(networks, *network); wn == nil {
+			log.Fatalf("Network %q not found", *network)
+		}
+	}
+
+	f, err := os.Open(fs.Arg(0))
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	defer f.Close()
+	conf, err := services.ParseWGQuick(f)
+	if err != nil {
+		log.Fatalf("Invalid wg-quick config: %v", err)
+	}
+
+	result, err := services.NewPeerService(networks).ImportWGQuick(wn.ID, conf, services.ImportOptions{AdoptKey: *adoptKey, DryRun: *dryRun})
+	if err != nil {
+		log.Fatalf("Import failed: %v", err)
+	}
+	for _, w := range result.Warnings {
+		log.Printf("Warning: %s", w)
+	}
+	for _, p := range result.Imported {
+		fmt.Printf("imported  %-24s %s\n", p.Name, p.AllowedIPs)
+	}
+	for _, c := range result.Conflicts {
+		fmt.Printf("skipped   %-24s line %d: %s\n", c.Name, c.Line, c.Reason)
+	}
+	verb := "Imported"
+	if result.DryRun {
+		verb = "Would import"
+	}
+	fmt.Printf("%s %d peers into %s, %d conflicts", verb, len(result.Imported), wn.Name, len(result.Conflicts))
+	if result.KeyAdopted {
+		fmt.Printf(", server key adopted")
+	}
+	fmt.Println()
+}
+
+// exportWGQuick in file wg-quick của một mạng ra stdout
+func exportWGQuick(networks *services.NetworkManager, args []string) {
+	wn := networks.Default()
+	if len(args) > 0 {
+		if wn = findNetwork(networks, args[0]); wn == nil {
+			log.Fatalf("Network %q not found", args[0])
+		}
+	}
+	conf, err := services.ExportWGQuick(wn.Network)
+	if err != nil {
+		log.Fatalf("Failed to export %s: %v", wn.Name, err)
+	}
+	fmt.Print(conf)
+}
+
+// restoreSnapshot khôi phục DB từ file snapshot JSON
+func restoreSnapshot(args []string) {
+	if len(args) != 1 {
+		log.Fatalf("Usage: restore-snapshot <file>")
+	}
+	data, err := os.ReadFile(args[0])
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	var snap services.Snapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		log.Fatalf("Invalid snapshot: %v", err)
+	}
+	if err := services.RestoreSnapshot(&snap); err != nil {
+		log.Fatalf("Failed to restore snapshot: %v", err)
+	}
+	log.Printf("Restored %d networks, %d peers, %d port forwards, %d domains, %d endpoints from snapshot of %s",
+		len(snap.Networks), len(snap.Peers), len(snap.PortForwards), len(snap.Domains), len(snap.Endpoints), snap.CreatedAt.Format(time.RFC3339))
+}
+
+// findNetwork tìm mạng theo ID hoặc tên
+func findNetwork(networks *services.NetworkManager, ref string) *services.WGNetwork {
+	for _, wn := range networks.All() {
+		if wn.Name == ref || strconv.FormatUint(uint64(wn.ID), 10) == ref {
+			return wn
+		}
+	}
+	return nil
+}
+
+// rotateMasterKey bọc lại mọi secret trong DB bằng master key mới (lấy từ WIRETIFY_NEW_MASTER_KEY hoặc sinh mới).
+// Với key file, key mới chỉ thay file cũ sau khi DB đã được cập nhật xong.
+func rotateMasterKey(cfg *config.Config) {
+	var (
+		newKey []byte
+		err    error
+	)
+	if encoded := os.Getenv("WIRETIFY_NEW_MASTER_KEY"); encoded != "" {
+		newKey, err = secrets.DecodeKey(encoded)
+	} else {
+		newKey, err = secrets.GenerateKey()
+	}
+	if err != nil {
+		log.Fatalf("Invalid new master key: %v", err)
+	}
+
+	if cfg.MasterKey != "" {
+		// Master key nằm trong biến môi trường: không thể tự thay, in key mới cho người vận hành
+		n, err := database.RewrapSecrets(newKey)
+		if err != nil {
+			log.Fatalf("Failed to re-encrypt secrets: %v", err)
+		}
+		log.Printf("Re-encrypted %d secrets. Set WIRETIFY_MASTER_KEY to the key below before starting the service:", n)
+		fmt.Println(secrets.EncodeKey(newKey))
+		return
+	}
+
+	tmpFile := cfg.MasterKeyFile + ".new"
+	if err := secrets.WriteKeyFile(tmpFile, newKey); err != nil {
+		log.Fatalf("%v", err)
+	}
+	n, err := database.RewrapSecrets(newKey)
+	if err != nil {
+		os.Remove(tmpFile)
+		log.Fatalf("Failed to re-encrypt secrets: %v", err)
+	}
+	if err := os.Rename(tmpFile, cfg.MasterKeyFile); err != nil {
+		log.Fatalf("Secrets were re-encrypted but the new key could not replace %s: %v. The new key is in %s, move it into place before starting the service", cfg.MasterKeyFile, err, tmpFile)
+	}
+	log.Printf("Re-encrypted %d secrets under a new master key in %s", n, cfg.MasterKeyFile)
+}
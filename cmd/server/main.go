@@ -1,12 +1,15 @@
 package main
 
 import (
+	"context"
 	"html/template"
 	"log"
+	"os"
 	"wiretify/internal/config"
 	"wiretify/internal/database"
 	"wiretify/internal/handlers"
 	"wiretify/internal/models"
+	"wiretify/internal/secrets"
 	"wiretify/internal/services"
 	"wiretify/internal/web"
 
@@ -21,42 +24,72 @@ func main() {
 		log.Fatalf("Failed to load config: %v", err)
 	}
 
-	// 2. Init DB
+	// 2. Init secrets & DB
+	if err := secrets.Init(cfg.MasterKey, cfg.MasterKeyFile); err != nil {
+		log.Fatalf("Failed to load master key: %v", err)
+	}
 	if err := database.InitDB(cfg.DatabasePath); err != nil {
 		log.Fatalf("Failed to init DB: %v", err)
 	}
 
-	// 3. Setup Network (Interface & NAT)
-	netSvc := services.NewNetworkService(cfg)
-	if err := netSvc.SetupInterface(); err != nil {
-		log.Printf("Warning: Interface setup failed: %v (May require root/NET_ADMIN)", err)
+	// 3. Load networks (mạng mặc định được tạo từ cấu hình WG_* lần đầu)
+	networks := services.NewNetworkManager(cfg)
+	if err := networks.Load(); err != nil {
+		log.Fatalf("Failed to load networks: %v", err)
 	}
-	if err := netSvc.SetupFirewall(); err != nil {
-		log.Printf("Warning: Firewall setup failed: %v", err)
+
+	// Lệnh quản trị một lần (không chạy web server)
+	if len(os.Args) > 1 {
+		runCommand(cfg, networks, os.Args[1:])
+		return
 	}
 
-	// Restore Port Forwards from DB
-	var activePortForwards []models.PortForward
-	database.DB.Find(&activePortForwards)
-	for _, pf := range activePortForwards {
-		if err := netSvc.AddPortForward(pf.PublicPort, pf.TargetNode, pf.TargetPort, pf.Protocol); err != nil {
-			log.Printf("Warning: Failed to restore port forward %d -> %s:%d : %v", pf.PublicPort, pf.TargetNode, pf.TargetPort, err)
-		}
+	// 4. Setup Network (Interface, NAT & WG sync) cho từng mạng
+	for _, wn := range networks.All() {
+		networks.Start(wn)
 	}
+	defer networks.Close()
 
-	// 4. WG Sync
-	wgSvc, err := services.NewWGService(cfg)
-	if err != nil {
-		log.Printf("Warning: WireGuard controller failed to init: %v", err)
-	} else {
-		defer wgSvc.Close()
-		// Sync initial peers from DB
-		var peers []models.Peer
-		database.DB.Find(&peers)
-		if err := wgSvc.SyncPeers(peers); err != nil {
-			log.Printf("Warning: Failed to sync initial peers to wg: %v", err)
+	// Restore Port Forwards from DB (bỏ qua các forward tới peer đang bị disable)
+	var disabledPeers []models.Peer
+	database.DB.Where("enabled = ?", false).Find(&disabledPeers)
+	suspended := make(map[string]bool, len(disabledPeers))
+	for _, p := range disabledPeers {
+		suspended[p.IP()] = true
+	}
+
+	var portForwards []models.PortForward
+	database.DB.Find(&portForwards)
+	activePortForwards := make(map[uint][]models.PortForward)
+	for _, pf := range portForwards {
+		if !suspended[pf.TargetNode] {
+			activePortForwards[pf.NetworkID] = append(activePortForwards[pf.NetworkID], pf)
 		}
 	}
+	for id, pfs := range activePortForwards {
+		wn := networks.Get(id)
+		if wn == nil {
+			wn = networks.Default()
+		}
+		wn.Net.RestorePortForwards(pfs)
+	}
+
+	// Background jobs (peer expiry, usage accounting, traffic history, live events)
+	peerSvc := services.NewPeerService(networks)
+	statsSvc := services.NewStatsSampler(cfg.StatsRawRetention, cfg.StatsRetention)
+	watcher := services.NewPeerWatcher(cfg.HandshakeTimeout)
+	watcher.Handle(services.NewConnectionLog().Record)
+	if networks.Default().WG != nil {
+		ctx := context.Background()
+		go services.NewExpiryScheduler(peerSvc, cfg.ExpiryGrace).Run(ctx)
+
+		monitor := services.NewDeviceMonitor(networks, cfg.MonitorInterval)
+		monitor.Subscribe(services.NewUsageService(peerSvc).Observe)
+		monitor.Subscribe(peerSvc.ObserveKeyRotations)
+		monitor.Subscribe(statsSvc.Observe)
+		monitor.Subscribe(watcher.Observe)
+		go monitor.Run(ctx)
+	}
 
 	// 5. API Server & HTML Renderer
 	e := echo.New()
@@ -66,12 +99,13 @@ func main() {
 	// Init Template engine
 	renderer := &web.TemplateRenderer{
 		Templates: map[string]*template.Template{
-			"dashboard.html":    template.Must(template.ParseFiles("web/templates/layout.html", "web/templates/dashboard.html")),
-			"port_forward.html": template.Must(template.ParseFiles("web/templates/layout.html", "web/templates/port_forward.html")),
-			"domains.html":      template.Must(template.ParseFiles("web/templates/layout.html", "web/templates/domains.html")),
-			"endpoints.html":    template.Must(template.ParseFiles("web/templates/layout.html", "web/templates/endpoints.html")),
-			"access_control.html":    template.Must(template.ParseFiles("web/templates/layout.html", "web/templates/access_control.html")),
-			"login.html":       template.Must(template.ParseFiles("web/templates/login.html")),
+			"dashboard.html":      template.Must(template.ParseFiles("web/templates/layout.html", "web/templates/dashboard.html")),
+			"port_forward.html":   template.Must(template.ParseFiles("web/templates/layout.html", "web/templates/port_forward.html")),
+			"domains.html":        template.Must(template.ParseFiles("web/templates/layout.html", "web/templates/domains.html")),
+			"endpoints.html":      template.Must(template.ParseFiles("web/templates/layout.html", "web/templates/endpoints.html")),
+			"access_control.html": template.Must(template.ParseFiles("web/templates/layout.html", "web/templates/access_control.html")),
+			"mesh.html":           template.Must(template.ParseFiles("web/templates/layout.html", "web/templates/mesh.html")),
+			"login.html":          template.Must(template.ParseFiles("web/templates/login.html")),
 		},
 	}
 	e.Renderer = renderer
@@ -82,7 +116,7 @@ func main() {
 	// API Routes
 	domSvc := services.NewDomainService(cfg)
 	api := e.Group("/api")
-	handlers.RegisterRoutes(e, api, wgSvc, netSvc, domSvc, cfg)
+	handlers.RegisterRoutes(e, api, networks, domSvc, peerSvc, statsSvc, watcher, cfg)
 
 	log.Printf("Wiretify starting on :8080...")
 	e.Logger.Fatal(e.Start(":8080"))
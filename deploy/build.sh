@@ -20,7 +20,7 @@ GO_CMD="/home/accnet/local-go/bin/go"
 if [ ! -x "$GO_CMD" ]; then
     GO_CMD="go"
 fi
-GOOS=linux GOARCH=amd64 $GO_CMD build -ldflags="-s -w" -o deploy/wiretify cmd/server/main.go
+GOOS=linux GOARCH=amd64 $GO_CMD build -ldflags="-s -w" -o deploy/wiretify ./cmd/server
 
 echo -e "${GREEN}[+] Copying frontend assets...${NC}"
 rm -rf deploy/web
@@ -5,6 +5,7 @@ go 1.24.0
 require (
 	github.com/coreos/go-iptables v0.8.0
 	github.com/labstack/echo/v4 v4.14.0
+	github.com/skip2/go-qrcode v0.0.0-20200617195104-da1b6568686e
 	github.com/spf13/viper v1.21.0
 	github.com/vishvananda/netlink v1.3.1
 	golang.zx2c4.com/wireguard/wgctrl v0.0.0-20241231184526-a9ab2273dd10
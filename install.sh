@@ -64,7 +64,7 @@ fi
 
 # Sử dụng variable GO executable
 GO_CMD=$(command -v go || echo "/snap/bin/go")
-$GO_CMD build -o wiretify cmd/server/main.go
+$GO_CMD build -o wiretify ./cmd/server
 
 # 6. Copy files to /opt
 echo -e "${GREEN}[+] Copying files to /opt/wiretify...${NC}"
@@ -2,19 +2,30 @@ package config
 
 import (
 	"strings"
+	"time"
 
 	"github.com/spf13/viper"
 )
 
 type Config struct {
-	ServerName     string `mapstructure:"SERVER_NAME"`
-	InterfaceName  string `mapstructure:"WG_INTERFACE"`
-	Port           int    `mapstructure:"WG_PORT"`
-	Address        string `mapstructure:"WG_ADDRESS"`
-	PrivateKey     string `mapstructure:"WG_PRIVATE_KEY"`
-	ServerEndpoint string `mapstructure:"SERVER_ENDPOINT"`
-	DatabasePath   string `mapstructure:"DB_PATH"`
-	AdminPassword  string `mapstructure:"ADMIN_PASSWORD"`
+	ServerName        string        `mapstructure:"SERVER_NAME"`
+	InterfaceName     string        `mapstructure:"WG_INTERFACE"`
+	Port              int           `mapstructure:"WG_PORT"`
+	Address           string        `mapstructure:"WG_ADDRESS"`
+	Address6          string        `mapstructure:"WG_ADDRESS6"`    // Optional IPv6 (ULA) address, e.g. fd86:ea04:1115::1/64
+	PrivateKey        string        `mapstructure:"WG_PRIVATE_KEY"` // Chỉ dùng lần đầu, sau đó key nằm trong key store (đã mã hoá)
+	ServerEndpoint    string        `mapstructure:"SERVER_ENDPOINT"`
+	PublicURL         string        `mapstructure:"PUBLIC_URL"` // URL của web UI trong share link, vd. https://vpn.example.com; rỗng: http://SERVER_ENDPOINT:8080
+	DatabasePath      string        `mapstructure:"DB_PATH"`
+	AdminPassword     string        `mapstructure:"ADMIN_PASSWORD"`
+	MasterKey         string        `mapstructure:"MASTER_KEY"`          // base64, 32 bytes; dùng để mã hoá secrets trong DB
+	MasterKeyFile     string        `mapstructure:"MASTER_KEY_FILE"`     // dùng khi MASTER_KEY trống, tự sinh nếu chưa có
+	PresharedKeys     bool          `mapstructure:"WG_PRESHARED_KEYS"`   // Mặc định bật PSK cho peer mới
+	ExpiryGrace       time.Duration `mapstructure:"PEER_EXPIRY_GRACE"`   // Peer hết hạn bị xoá sau khoảng này (0: giữ lại)
+	MonitorInterval   time.Duration `mapstructure:"MONITOR_INTERVAL"`    // Chu kỳ đọc thống kê từ kernel
+	HandshakeTimeout  time.Duration `mapstructure:"HANDSHAKE_TIMEOUT"`   // Peer coi là đang kết nối nếu handshake gần nhất trong khoảng này
+	StatsRawRetention time.Duration `mapstructure:"STATS_RAW_RETENTION"` // Giữ sample theo phút trong khoảng này
+	StatsRetention    time.Duration `mapstructure:"STATS_RETENTION"`     // Giữ sample theo giờ trong khoảng này
 }
 
 func LoadConfig() (*Config, error) {
@@ -22,9 +33,17 @@ func LoadConfig() (*Config, error) {
 	viper.SetDefault("WG_INTERFACE", "wg0")
 	viper.SetDefault("WG_PORT", 51820)
 	viper.SetDefault("WG_ADDRESS", "10.8.0.1/24")
+	viper.SetDefault("WG_ADDRESS6", "")
 	viper.SetDefault("SERVER_ENDPOINT", "127.0.0.1")
+	viper.SetDefault("PUBLIC_URL", "")
 	viper.SetDefault("DB_PATH", "wiretify.db")
-
+	viper.SetDefault("MASTER_KEY_FILE", "master.key")
+	viper.SetDefault("WG_PRESHARED_KEYS", true)
+	viper.SetDefault("PEER_EXPIRY_GRACE", "168h")
+	viper.SetDefault("MONITOR_INTERVAL", "10s")
+	viper.SetDefault("HANDSHAKE_TIMEOUT", "3m")
+	viper.SetDefault("STATS_RAW_RETENTION", "48h")
+	viper.SetDefault("STATS_RETENTION", "2160h")
 
 	viper.SetEnvPrefix("WIRETIFY")
 	viper.AutomaticEnv()
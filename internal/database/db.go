@@ -1,7 +1,9 @@
 package database
 
 import (
+	"fmt"
 	"log"
+	"strings"
 	"wiretify/internal/models"
 
 	"gorm.io/driver/sqlite"
@@ -12,16 +14,132 @@ var DB *gorm.DB
 
 func InitDB(dbPath string) error {
 	var err error
-	DB, err = gorm.Open(sqlite.Open(dbPath), &gorm.Config{})
+	DB, err = gorm.Open(sqlite.Open(dbPath), &gorm.Config{TranslateError: true})
 	if err != nil {
 		return err
 	}
 
 	log.Println("Migrating database...")
-	err = DB.AutoMigrate(&models.Peer{}, &models.Setting{}, &models.PortForward{}, &models.Domain{}, &models.Endpoint{})
+	err = DB.AutoMigrate(&models.Network{}, &models.Peer{}, &models.Setting{}, &models.PortForward{}, &models.Domain{}, &models.Endpoint{}, &models.Profile{}, &models.ShareLink{}, &models.PeerEvent{}, &models.PeerSample{}, &models.Reservation{}, &models.Group{}, &models.ACLRule{})
 	if err != nil {
 		return err
 	}
 
+	if err := dropPeerNameIndex(); err != nil {
+		return fmt.Errorf("failed to drop the old peer name index: %v", err)
+	}
+
+	if err := createPeerAddressIndexes(); err != nil {
+		return fmt.Errorf("failed to create peer address indexes: %v", err)
+	}
+
+	if err := seedProfiles(); err != nil {
+		return err
+	}
+
+	if err := migrateExitNodeFlag(); err != nil {
+		return fmt.Errorf("failed to migrate use_as_exit_node: %v", err)
+	}
+
+	// Migration: mã hoá private key/PSK còn lưu plaintext
+	if err := EncryptPlaintextSecrets(DB); err != nil {
+		return fmt.Errorf("failed to encrypt stored secrets: %v", err)
+	}
+
+	return nil
+}
+
+// seedProfiles tạo các profile mặc định khi DB chưa có profile nào
+func seedProfiles() error {
+	var count int64
+	if err := DB.Model(&models.Profile{}).Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return nil
+	}
+
+	defaults := []models.Profile{
+		{Name: "Full tunnel", Mode: models.ProfileModeFull, PersistentKeepalive: 25},
+		{Name: "Split tunnel", Mode: models.ProfileModeSplit, PersistentKeepalive: 25},
+	}
+	return DB.Create(&defaults).Error
+}
+
+// migrateExitNodeFlag chạy một lần cho DB từ phiên bản cũ: do default:true, mọi peer cũ đều được lưu
+// use_as_exit_node = true bất kể lựa chọn khi tạo, và phiên bản cũ render các peer này thành split tunnel.
+// Đặt lại thành false để peer không gán profile giữ split tunnel như trước.
+func migrateExitNodeFlag() error {
+	var count int64
+	if err := DB.Model(&models.Setting{}).Where("key = ?", models.SettingExitNodeMigrated).Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return nil
+	}
+
+	return DB.Transaction(func(tx *gorm.DB) error {
+		res := tx.Exec("UPDATE peers SET use_as_exit_node = ? WHERE use_as_exit_node = ?", false, true)
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected > 0 {
+			log.Printf("Reset use_as_exit_node on %d existing peers to keep their split tunnel", res.RowsAffected)
+		}
+		return tx.Create(&models.Setting{Key: models.SettingExitNodeMigrated, Value: "1"}).Error
+	})
+}
+
+// dropPeerNameIndex xoá unique index trên tên peer của phiên bản cũ:
+// tên peer giờ chỉ unique trong mỗi mạng (idx_peers_network_name)
+func dropPeerNameIndex() error {
+	if !DB.Migrator().HasIndex(&models.Peer{}, "idx_peers_name") {
+		return nil
+	}
+	log.Println("Dropping the global unique index on peer names, names are now unique per network")
+	return DB.Migrator().DropIndex(&models.Peer{}, "idx_peers_name")
+}
+
+// createPeerAddressIndexes đảm bảo hai peer chưa xoá không thể có cùng địa chỉ, kể cả khi
+// hai request tạo peer chạy song song. Partial index để peer đã soft-delete trả lại địa chỉ cho pool.
+// DB từ phiên bản cũ có thể đã có địa chỉ trùng; khi đó báo rõ các peer bị trùng thay vì lỗi constraint của SQLite.
+func createPeerAddressIndexes() error {
+	for _, idx := range []struct {
+		name, column, where string
+	}{
+		{"idx_peers_allowed_ips", "allowed_ips", "deleted_at IS NULL"},
+		{"idx_peers_allowed_ips6", "allowed_ips6", "deleted_at IS NULL AND allowed_ips6 <> ''"},
+	} {
+		if err := checkDuplicateAddresses(idx.column, idx.where); err != nil {
+			return err
+		}
+		if err := DB.Exec(fmt.Sprintf("CREATE UNIQUE INDEX IF NOT EXISTS %s ON peers (%s) WHERE %s", idx.name, idx.column, idx.where)).Error; err != nil {
+			return err
+		}
+	}
 	return nil
 }
+
+// checkDuplicateAddresses trả về lỗi liệt kê các địa chỉ đang được nhiều peer chưa xoá dùng chung
+func checkDuplicateAddresses(column, where string) error {
+	var dups []struct {
+		Address string
+		Peers   string
+	}
+	err := DB.Raw(fmt.Sprintf(
+		"SELECT %[1]s AS address, group_concat(name || ' (id ' || id || ')', ', ') AS peers FROM peers WHERE %[2]s GROUP BY %[1]s HAVING COUNT(*) > 1",
+		column, where)).Scan(&dups).Error
+	if err != nil {
+		return err
+	}
+	if len(dups) == 0 {
+		return nil
+	}
+
+	conflicts := make([]string, len(dups))
+	for i, d := range dups {
+		conflicts[i] = fmt.Sprintf("%s is used by %s", d.Address, d.Peers)
+	}
+	return fmt.Errorf("peers must have unique addresses but %s; change the address of (or delete) all but one peer of each group with the previous version, then start again",
+		strings.Join(conflicts, "; "))
+}
@@ -0,0 +1,90 @@
+package database
+
+import (
+	"fmt"
+	"log"
+	"wiretify/internal/secrets"
+
+	"gorm.io/gorm"
+)
+
+// sealedColumn là một cột lưu secret được mã hoá bằng master key
+type sealedColumn struct {
+	table  string
+	pk     string
+	column string
+}
+
+var sealedColumns = []sealedColumn{
+	{table: "peers", pk: "id", column: "private_key"},
+	{table: "peers", pk: "id", column: "preshared_key"},
+	{table: "networks", pk: "id", column: "private_key"},
+}
+
+// EncryptPlaintextSecrets mã hoá các secret còn lưu dạng plaintext (dữ liệu từ phiên bản cũ)
+func EncryptPlaintextSecrets(db *gorm.DB) error {
+	n, err := transformSecrets(db, func(value string) (string, error) {
+		if secrets.IsSealed(value) {
+			return value, nil
+		}
+		return secrets.Seal(value)
+	})
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		log.Printf("Encrypted %d plaintext secrets", n)
+	}
+	return nil
+}
+
+// RewrapSecrets bọc lại mọi secret bằng master key mới trong một transaction.
+// Sau khi thành công, newKey phải được dùng làm master key.
+func RewrapSecrets(newKey []byte) (int, error) {
+	var total int
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		// Secret còn plaintext cũng được mã hoá luôn để mọi thứ nằm dưới key mới
+		if err := EncryptPlaintextSecrets(tx); err != nil {
+			return err
+		}
+		n, err := transformSecrets(tx, func(value string) (string, error) {
+			return secrets.Rewrap(value, newKey)
+		})
+		total = n
+		return err
+	})
+	return total, err
+}
+
+// transformSecrets áp fn lên mọi giá trị khác rỗng trong sealedColumns (kể cả bản ghi đã soft-delete)
+// và trả về số giá trị đã thay đổi
+func transformSecrets(db *gorm.DB, fn func(string) (string, error)) (int, error) {
+	changed := 0
+	for _, col := range sealedColumns {
+		query := fmt.Sprintf("SELECT CAST(%s AS TEXT) AS pk, %s AS value FROM %s WHERE %s <> ''", col.pk, col.column, col.table, col.column)
+
+		var rows []struct {
+			PK    string
+			Value string
+		}
+		if err := db.Raw(query).Scan(&rows).Error; err != nil {
+			return changed, err
+		}
+
+		for _, row := range rows {
+			updated, err := fn(row.Value)
+			if err != nil {
+				return changed, fmt.Errorf("%s.%s (%s=%s): %v", col.table, col.column, col.pk, row.PK, err)
+			}
+			if updated == row.Value {
+				continue
+			}
+			update := fmt.Sprintf("UPDATE %s SET %s = ? WHERE %s = ?", col.table, col.column, col.pk)
+			if err := db.Exec(update, updated, row.PK).Error; err != nil {
+				return changed, err
+			}
+			changed++
+		}
+	}
+	return changed, nil
+}
@@ -0,0 +1,46 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"wiretify/internal/secrets"
+
+	"gorm.io/gorm/schema"
+)
+
+func init() {
+	schema.RegisterSerializer("secret", SecretSerializer{})
+}
+
+// SecretSerializer mã hoá/giải mã trong suốt các field string được đánh dấu `gorm:"serializer:secret"`
+type SecretSerializer struct{}
+
+// Scan implements schema.SerializerInterface
+func (SecretSerializer) Scan(ctx context.Context, field *schema.Field, dst reflect.Value, dbValue interface{}) error {
+	var stored string
+	switch v := dbValue.(type) {
+	case nil:
+	case string:
+		stored = v
+	case []byte:
+		stored = string(v)
+	default:
+		return fmt.Errorf("unsupported secret value type %T for field %s", dbValue, field.Name)
+	}
+
+	plaintext, err := secrets.Open(stored)
+	if err != nil {
+		return fmt.Errorf("failed to decrypt field %s: %v", field.Name, err)
+	}
+	return field.Set(ctx, dst, plaintext)
+}
+
+// Value implements schema.SerializerValuerInterface
+func (SecretSerializer) Value(ctx context.Context, field *schema.Field, dst reflect.Value, fieldValue interface{}) (interface{}, error) {
+	plaintext, ok := fieldValue.(string)
+	if !ok {
+		return nil, fmt.Errorf("unsupported secret field type %T for field %s", fieldValue, field.Name)
+	}
+	return secrets.Seal(plaintext)
+}
@@ -0,0 +1,325 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"wiretify/internal/database"
+	"wiretify/internal/models"
+	"wiretify/internal/services"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+// maxACLPorts là số port tối đa của một rule (giới hạn của iptables multiport, dải port tính là 2)
+const maxACLPorts = 15
+
+// aclRuleRequest dùng cho cả tạo và sửa rule; field bỏ trống khi sửa thì giữ nguyên
+type aclRuleRequest struct {
+	Priority    *int    `json:"priority"`
+	Description *string `json:"description"`
+	SourceType  *string `json:"source_type"`
+	Source      *string `json:"source"` // ID/tên peer hoặc group, hoặc CIDR
+	DestType    *string `json:"dest_type"`
+	Dest        *string `json:"dest"`
+	Protocol    *string `json:"protocol"`
+	Ports       *string `json:"ports"`
+	Action      *string `json:"action"`
+	Enabled     *bool   `json:"enabled"`
+}
+
+// apply ghi các field có trong request vào rule
+func (req aclRuleRequest) apply(rule *models.ACLRule) {
+	set := func(dst *string, src *string) {
+		if src != nil {
+			*dst = strings.TrimSpace(*src)
+		}
+	}
+	if req.Priority != nil {
+		rule.Priority = *req.Priority
+	}
+	set(&rule.Description, req.Description)
+	set(&rule.SourceType, req.SourceType)
+	set(&rule.Source, req.Source)
+	set(&rule.DestType, req.DestType)
+	set(&rule.Dest, req.Dest)
+	set(&rule.Protocol, req.Protocol)
+	set(&rule.Ports, req.Ports)
+	set(&rule.Action, req.Action)
+	if req.Enabled != nil {
+		rule.Enabled = *req.Enabled
+	}
+}
+
+// GetACL trả về chế độ mặc định, các rule theo thứ tự so khớp và lệnh iptables đã biên dịch của mạng được chọn
+func (h *PeerHandler) GetACL(c echo.Context) error {
+	wn, err := h.selectedNetwork(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	var network models.Network
+	if err := database.DB.First(&network, wn.ID).Error; err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	rules := []models.ACLRule{}
+	if err := database.DB.Where("network_id = ?", wn.ID).Order("priority, id").Find(&rules).Error; err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	set, err := services.CompileACL(database.DB, wn.ID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+
+	mode := network.ACLMode
+	if mode == "" {
+		mode = models.ACLModeAllow
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"network_id": wn.ID,
+		"interface":  wn.InterfaceName,
+		"chain":      services.ACLChain(wn.InterfaceName),
+		"mode":       mode,
+		"rules":      rules,
+		"commands":   set.Commands(),
+	})
+}
+
+// SetACLMode đổi chế độ mặc định (allow/deny) của mạng và nạp lại chain ACL
+func (h *PeerHandler) SetACLMode(c echo.Context) error {
+	var req struct {
+		Mode string `json:"mode"`
+	}
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
+	}
+	if req.Mode != models.ACLModeAllow && req.Mode != models.ACLModeDeny {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "mode must be allow or deny"})
+	}
+
+	wn, err := h.selectedNetwork(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	err = h.peerSvc.ChangeACL(wn.ID, func(tx *gorm.DB) error {
+		return tx.Model(&models.Network{}).Where("id = ?", wn.ID).Update("acl_mode", req.Mode).Error
+	})
+	if err != nil {
+		return aclChangeError(c, err)
+	}
+	return c.JSON(http.StatusOK, map[string]string{"mode": req.Mode})
+}
+
+// CreateACLRule thêm rule vào mạng được chọn; không chỉ định priority thì rule được xếp cuối
+func (h *PeerHandler) CreateACLRule(c echo.Context) error {
+	var req aclRuleRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
+	}
+
+	wn, err := h.selectedNetwork(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	rule := models.ACLRule{
+		NetworkID:  wn.ID,
+		SourceType: models.ACLTargetAny,
+		DestType:   models.ACLTargetAny,
+		Protocol:   models.ACLProtocolAll,
+		Action:     models.ACLActionAccept,
+		Enabled:    true,
+	}
+	req.apply(&rule)
+
+	err = h.peerSvc.ChangeACL(wn.ID, func(tx *gorm.DB) error {
+		if req.Priority == nil {
+			var last models.ACLRule
+			if err := tx.Where("network_id = ?", wn.ID).Order("priority desc").Limit(1).Find(&last).Error; err != nil {
+				return err
+			}
+			rule.Priority = last.Priority + 10
+		}
+		if err := validateACLRule(tx, &rule); err != nil {
+			return err
+		}
+		return tx.Create(&rule).Error
+	})
+	if err != nil {
+		return aclChangeError(c, err)
+	}
+	return c.JSON(http.StatusCreated, rule)
+}
+
+// UpdateACLRule sửa các field có trong request của rule rồi nạp lại chain ACL
+func (h *PeerHandler) UpdateACLRule(c echo.Context) error {
+	var req aclRuleRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
+	}
+
+	var rule models.ACLRule
+	if err := database.DB.First(&rule, c.Param("id")).Error; err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Rule not found"})
+	}
+
+	err := h.peerSvc.ChangeACL(rule.NetworkID, func(tx *gorm.DB) error {
+		// Rule bị xoá đồng thời trả về gorm.ErrRecordNotFound
+		if err := tx.First(&rule, rule.ID).Error; err != nil {
+			return err
+		}
+		// Đổi loại source/destination mà không gửi giá trị mới thì giá trị cũ không còn hợp lệ
+		if req.SourceType != nil && req.Source == nil {
+			rule.Source = ""
+		}
+		if req.DestType != nil && req.Dest == nil {
+			rule.Dest = ""
+		}
+		req.apply(&rule)
+		if err := validateACLRule(tx, &rule); err != nil {
+			return err
+		}
+		return tx.Select("*").Save(&rule).Error
+	})
+	if err != nil {
+		return aclChangeError(c, err)
+	}
+	return c.JSON(http.StatusOK, rule)
+}
+
+func (h *PeerHandler) DeleteACLRule(c echo.Context) error {
+	var rule models.ACLRule
+	if err := database.DB.First(&rule, c.Param("id")).Error; err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Rule not found"})
+	}
+
+	err := h.peerSvc.ChangeACL(rule.NetworkID, func(tx *gorm.DB) error {
+		return tx.Delete(&rule).Error
+	})
+	if err != nil {
+		return aclChangeError(c, err)
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// aclChangeError trả response cho lỗi từ PeerService.ChangeACL: lỗi validate (peer/group không tồn tại, port sai...),
+// rule không còn tồn tại hoặc lỗi áp dụng chain vào kernel (DB đã được rollback)
+func aclChangeError(c echo.Context, err error) error {
+	var reqErr *requestError
+	switch {
+	case errors.As(err, &reqErr):
+		return c.JSON(reqErr.status, map[string]string{"error": reqErr.message})
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Rule not found"})
+	}
+	return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+}
+
+// validateACLRule kiểm tra và chuẩn hoá rule: peer/group được lưu bằng ID, CIDR và danh sách port ở dạng chuẩn
+func validateACLRule(tx *gorm.DB, rule *models.ACLRule) error {
+	var err error
+	if rule.Source, err = validateACLTarget(tx, rule.NetworkID, "source", rule.SourceType, rule.Source); err != nil {
+		return err
+	}
+	if rule.Dest, err = validateACLTarget(tx, rule.NetworkID, "dest", rule.DestType, rule.Dest); err != nil {
+		return err
+	}
+
+	switch rule.Protocol {
+	case models.ACLProtocolTCP, models.ACLProtocolUDP:
+		if rule.Ports, err = normalizePorts(rule.Ports); err != nil {
+			return err
+		}
+	case models.ACLProtocolAll, models.ACLProtocolICMP:
+		if rule.Ports != "" {
+			return badRequest("ports can only be used with tcp or udp")
+		}
+	default:
+		return badRequest("Invalid protocol %q, expected all, tcp, udp or icmp", rule.Protocol)
+	}
+
+	if rule.Action != models.ACLActionAccept && rule.Action != models.ACLActionDrop {
+		return badRequest("Invalid action %q, expected accept or drop", rule.Action)
+	}
+	return nil
+}
+
+// validateACLTarget kiểm tra source/destination và trả về giá trị được lưu
+func validateACLTarget(tx *gorm.DB, networkID uint, field, kind, value string) (string, error) {
+	switch kind {
+	case models.ACLTargetAny:
+		return "", nil
+	case models.ACLTargetCIDR:
+		prefix, err := netip.ParsePrefix(value)
+		if err != nil {
+			// Cho phép nhập một địa chỉ đơn lẻ
+			addr, addrErr := netip.ParseAddr(value)
+			if addrErr != nil {
+				return "", badRequest("Invalid %s CIDR %q", field, value)
+			}
+			prefix = netip.PrefixFrom(addr, addr.BitLen())
+		}
+		return prefix.Masked().String(), nil
+	case models.ACLTargetPeer:
+		var peer models.Peer
+		query := tx.Where("network_id = ?", networkID)
+		if id, err := strconv.ParseUint(value, 10, 64); err == nil {
+			query = query.Where("id = ?", id)
+		} else {
+			query = query.Where("name = ?", value)
+		}
+		if err := query.Limit(1).Find(&peer).Error; err != nil {
+			return "", err
+		}
+		if peer.ID == 0 {
+			return "", badRequest("%s peer %q not found in this network", field, value)
+		}
+		return strconv.FormatUint(uint64(peer.ID), 10), nil
+	case models.ACLTargetGroup:
+		group := findGroup(tx, value)
+		if group == nil {
+			return "", badRequest("%s group %q not found", field, value)
+		}
+		return strconv.FormatUint(uint64(group.ID), 10), nil
+	}
+	return "", badRequest("Invalid %s_type %q, expected any, peer, group or cidr", field, kind)
+}
+
+// normalizePorts kiểm tra danh sách port đích dạng "22,80,443" hoặc "8000-8100"
+func normalizePorts(raw string) (string, error) {
+	if raw == "" {
+		return "", nil
+	}
+	var parts []string
+	count := 0
+	for _, item := range strings.Split(raw, ",") {
+		item = strings.TrimSpace(item)
+		bounds := strings.SplitN(item, "-", 2)
+		ports := make([]int, len(bounds))
+		for i, b := range bounds {
+			p, err := strconv.Atoi(strings.TrimSpace(b))
+			if err != nil || p < 1 || p > 65535 {
+				return "", badRequest("Invalid port %q", item)
+			}
+			ports[i] = p
+		}
+		if len(ports) == 2 {
+			if ports[0] >= ports[1] {
+				return "", badRequest("Invalid port range %q", item)
+			}
+			parts = append(parts, fmt.Sprintf("%d-%d", ports[0], ports[1]))
+		} else {
+			parts = append(parts, strconv.Itoa(ports[0]))
+		}
+		count += len(ports)
+	}
+	if count > maxACLPorts {
+		return "", badRequest("A rule can match at most %d ports (a range counts as two)", maxACLPorts)
+	}
+	return strings.Join(parts, ","), nil
+}
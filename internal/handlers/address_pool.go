@@ -0,0 +1,311 @@
+package handlers
+
+import (
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/netip"
+	"sort"
+	"strings"
+	"wiretify/internal/database"
+	"wiretify/internal/models"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+// addrRange là một dải địa chỉ liên tục [from, to]
+type addrRange struct {
+	from, to netip.Addr
+}
+
+func (r addrRange) contains(ip netip.Addr) bool {
+	return r.from.Compare(ip) <= 0 && ip.Compare(r.to) <= 0
+}
+
+func (r addrRange) overlaps(o addrRange) bool {
+	return r.from.Compare(o.to) <= 0 && o.from.Compare(r.to) <= 0
+}
+
+func (r addrRange) size() *big.Int {
+	n := new(big.Int).Sub(addrInt(r.to), addrInt(r.from))
+	return n.Add(n, big.NewInt(1))
+}
+
+func (r addrRange) String() string {
+	if r.from == r.to {
+		return r.from.String()
+	}
+	return r.from.String() + "-" + r.to.String()
+}
+
+func addrInt(a netip.Addr) *big.Int {
+	b := a.As16()
+	return new(big.Int).SetBytes(b[:])
+}
+
+// parseAddrRange nhận một địa chỉ, một dải "a-b" hoặc một CIDR
+func parseAddrRange(raw string) (addrRange, error) {
+	raw = strings.TrimSpace(raw)
+	if strings.Contains(raw, "/") {
+		p, err := netip.ParsePrefix(raw)
+		if err != nil {
+			return addrRange{}, err
+		}
+		p = p.Masked()
+		return addrRange{from: p.Addr(), to: lastAddr(p)}, nil
+	}
+	start, end, isRange := strings.Cut(raw, "-")
+	from, err := netip.ParseAddr(strings.TrimSpace(start))
+	if err != nil {
+		return addrRange{}, err
+	}
+	to := from
+	if isRange {
+		if to, err = netip.ParseAddr(strings.TrimSpace(end)); err != nil {
+			return addrRange{}, err
+		}
+	}
+	from, to = from.Unmap(), to.Unmap()
+	if from.Is4() != to.Is4() {
+		return addrRange{}, fmt.Errorf("%s mixes IPv4 and IPv6", raw)
+	}
+	if to.Less(from) {
+		return addrRange{}, fmt.Errorf("%s ends before it starts", raw)
+	}
+	return addrRange{from: from, to: to}, nil
+}
+
+// lastAddr trả về địa chỉ cuối cùng của prefix
+func lastAddr(p netip.Prefix) netip.Addr {
+	b := p.Masked().Addr().AsSlice()
+	bits := p.Bits()
+	for i := range b {
+		for j := 0; j < 8; j++ {
+			if i*8+j >= bits {
+				b[i] |= 0x80 >> j
+			}
+		}
+	}
+	addr, _ := netip.AddrFromSlice(b)
+	return addr
+}
+
+// hostRange là dải địa chỉ gán được cho peer trong subnet (bỏ network và broadcast của IPv4)
+func hostRange(p netip.Prefix) (addrRange, bool) {
+	p = p.Masked()
+	r := addrRange{from: p.Addr().Next(), to: lastAddr(p)}
+	if p.Addr().Is4() {
+		r.to = r.to.Prev()
+	}
+	if !r.from.IsValid() || !r.to.IsValid() || r.to.Less(r.from) {
+		return addrRange{}, false
+	}
+	return r, true
+}
+
+// loadReservations trả về các dải địa chỉ được giữ lại của mạng
+func loadReservations(db *gorm.DB, networkID uint) []addrRange {
+	var reservations []models.Reservation
+	db.Where("network_id = ?", networkID).Find(&reservations)
+
+	ranges := make([]addrRange, 0, len(reservations))
+	for _, r := range reservations {
+		if ar, err := parseAddrRange(r.StartIP + "-" + r.EndIP); err == nil {
+			ranges = append(ranges, ar)
+		}
+	}
+	return ranges
+}
+
+func (h *PeerHandler) ListReservations(c echo.Context) error {
+	query, err := h.networkScope(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	var reservations []models.Reservation
+	query.Order("network_id, id").Find(&reservations)
+	return c.JSON(http.StatusOK, reservations)
+}
+
+// CreateReservation giữ một dải địa chỉ của mạng được chọn; range là một IP, "a-b" hoặc CIDR
+func (h *PeerHandler) CreateReservation(c echo.Context) error {
+	var req struct {
+		Range       string `json:"range"`
+		Description string `json:"description"`
+	}
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
+	}
+
+	wn, err := h.selectedNetwork(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	r, err := parseAddrRange(req.Range)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid range: " + err.Error()})
+	}
+
+	inside := false
+	for _, pool := range networkPools(wn) {
+		if p, err := netip.ParsePrefix(pool); err == nil && p.Masked().Contains(r.from) && p.Masked().Contains(r.to) {
+			inside = true
+		}
+	}
+	if !inside {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("%s is outside of the %s subnets", r, wn.Name)})
+	}
+	for _, other := range loadReservations(database.DB, wn.ID) {
+		if other.overlaps(r) {
+			return c.JSON(http.StatusConflict, map[string]string{"error": fmt.Sprintf("%s overlaps reservation %s", r, other)})
+		}
+	}
+
+	reservation := models.Reservation{
+		NetworkID:   wn.ID,
+		StartIP:     r.from.String(),
+		EndIP:       r.to.String(),
+		Description: strings.TrimSpace(req.Description),
+	}
+	if err := database.DB.Create(&reservation).Error; err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	return c.JSON(http.StatusCreated, reservation)
+}
+
+func (h *PeerHandler) DeleteReservation(c echo.Context) error {
+	var reservation models.Reservation
+	if err := database.DB.First(&reservation, c.Param("id")).Error; err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Reservation not found"})
+	}
+	database.DB.Delete(&reservation)
+	return c.NoContent(http.StatusNoContent)
+}
+
+type poolAssignment struct {
+	IP       string `json:"ip"`
+	PeerID   uint   `json:"peer_id"`
+	PeerName string `json:"peer_name"`
+	Enabled  bool   `json:"enabled"`
+}
+
+type poolSummary struct {
+	Subnet       string               `json:"subnet"`
+	ServerIP     string               `json:"server_ip"`
+	Total        *big.Int             `json:"total"`    // Số địa chỉ gán được cho peer (không gồm IP server)
+	Used         int                  `json:"used"`     // Đã gán cho peer
+	Reserved     *big.Int             `json:"reserved"` // Nằm trong reservation và chưa gán
+	Free         *big.Int             `json:"free"`     // Còn cấp phát tự động được
+	NextFree     string               `json:"next_free,omitempty"`
+	Assignments  []poolAssignment     `json:"assignments"`
+	FreeRanges   []string             `json:"free_ranges"`
+	Reservations []models.Reservation `json:"reservations"`
+}
+
+// GetAddressPool cho biết địa chỉ nào của mạng đã dùng, đang được giữ và còn trống
+func (h *PeerHandler) GetAddressPool(c echo.Context) error {
+	wn, err := h.selectedNetwork(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	var peers []models.Peer
+	database.DB.Where("network_id = ?", wn.ID).Order("id").Find(&peers)
+	var reservations []models.Reservation
+	database.DB.Where("network_id = ?", wn.ID).Order("id").Find(&reservations)
+
+	var pools []poolSummary
+	for _, cidr := range networkPools(wn) {
+		prefix, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			continue
+		}
+		hosts, ok := hostRange(prefix)
+		if !ok {
+			continue
+		}
+		server := prefix.Addr().Unmap()
+		summary := poolSummary{
+			Subnet:       prefix.Masked().String(),
+			ServerIP:     server.String(),
+			Assignments:  []poolAssignment{},
+			FreeRanges:   []string{},
+			Reservations: []models.Reservation{},
+		}
+
+		blocked := []addrRange{{server, server}}
+		for _, p := range peers {
+			for _, addr := range []string{p.AllowedIPs, p.AllowedIPs6} {
+				pp, err := netip.ParsePrefix(addr)
+				if err != nil || !hosts.contains(pp.Addr().Unmap()) {
+					continue
+				}
+				ip := pp.Addr().Unmap()
+				summary.Assignments = append(summary.Assignments, poolAssignment{IP: ip.String(), PeerID: p.ID, PeerName: p.Name, Enabled: p.Enabled})
+				blocked = append(blocked, addrRange{ip, ip})
+			}
+		}
+		for _, res := range reservations {
+			r, err := parseAddrRange(res.StartIP + "-" + res.EndIP)
+			if err != nil || !prefix.Masked().Contains(r.from) {
+				continue
+			}
+			summary.Reservations = append(summary.Reservations, res)
+			blocked = append(blocked, r)
+		}
+
+		free := freeRanges(hosts, blocked)
+		summary.Total = hosts.size()
+		if hosts.contains(server) {
+			summary.Total.Sub(summary.Total, big.NewInt(1))
+		}
+		summary.Used = len(summary.Assignments)
+		summary.Free = new(big.Int)
+		for _, r := range free {
+			summary.Free.Add(summary.Free, r.size())
+			summary.FreeRanges = append(summary.FreeRanges, r.String())
+		}
+		if len(free) > 0 {
+			summary.NextFree = free[0].from.String()
+		}
+		summary.Reserved = new(big.Int).Sub(summary.Total, summary.Free)
+		summary.Reserved.Sub(summary.Reserved, big.NewInt(int64(summary.Used)))
+		pools = append(pools, summary)
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"network_id": wn.ID,
+		"pools":      pools,
+	})
+}
+
+// freeRanges trả về các khoảng trống trong hosts sau khi bỏ các dải blocked
+func freeRanges(hosts addrRange, blocked []addrRange) []addrRange {
+	sort.Slice(blocked, func(i, j int) bool { return blocked[i].from.Less(blocked[j].from) })
+
+	var free []addrRange
+	cursor := hosts.from
+	for _, b := range blocked {
+		if !cursor.IsValid() || hosts.to.Less(cursor) {
+			break
+		}
+		if b.to.Less(cursor) {
+			continue
+		}
+		if cursor.Less(b.from) {
+			end := b.from.Prev()
+			if hosts.to.Less(end) {
+				end = hosts.to
+			}
+			free = append(free, addrRange{cursor, end})
+		}
+		cursor = b.to.Next()
+	}
+	if cursor.IsValid() && !hosts.to.Less(cursor) {
+		free = append(free, addrRange{cursor, hosts.to})
+	}
+	return free
+}
@@ -0,0 +1,36 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"wiretify/internal/services"
+
+	"github.com/labstack/echo/v4"
+)
+
+// ExportWGQuick tải file wg-quick phía server của mạng được chọn, dùng được với `wg-quick up` khi Wiretify không chạy
+func (h *PeerHandler) ExportWGQuick(c echo.Context) error {
+	wn, err := h.selectedNetwork(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	conf, err := services.ExportWGQuick(wn.Network)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+
+	c.Response().Header().Set(echo.HeaderContentDisposition, fmt.Sprintf("attachment; filename=%s.conf", wn.InterfaceName))
+	return c.Blob(http.StatusOK, "text/plain; charset=utf-8", []byte(conf))
+}
+
+// ExportSnapshot tải bản JSON của toàn bộ cấu hình; khôi phục bằng `wiretify restore-snapshot <file>`
+func (h *PeerHandler) ExportSnapshot(c echo.Context) error {
+	snap, err := services.ExportSnapshot()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+
+	c.Response().Header().Set(echo.HeaderContentDisposition, fmt.Sprintf("attachment; filename=wiretify-snapshot-%s.json", snap.CreatedAt.Format("20060102-150405")))
+	return c.JSONPretty(http.StatusOK, snap, "  ")
+}
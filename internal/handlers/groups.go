@@ -0,0 +1,346 @@
+package handlers
+
+import (
+	"archive/zip"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"wiretify/internal/database"
+	"wiretify/internal/models"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+func (h *PeerHandler) ListGroups(c echo.Context) error {
+	type groupInfo struct {
+		models.Group
+		Peers int64 `json:"peers"`
+	}
+
+	var groups []models.Group
+	database.DB.Order("name").Find(&groups)
+
+	result := make([]groupInfo, 0, len(groups))
+	for _, g := range groups {
+		info := groupInfo{Group: g}
+		database.DB.Model(&models.Peer{}).Where("group_id = ?", g.ID).Count(&info.Peers)
+		result = append(result, info)
+	}
+	return c.JSON(http.StatusOK, result)
+}
+
+func (h *PeerHandler) CreateGroup(c echo.Context) error {
+	var req struct {
+		Name        string `json:"name"`
+		Description string `json:"description"`
+	}
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Name is required"})
+	}
+	if _, err := strconv.ParseUint(req.Name, 10, 64); err == nil || req.Name == groupNone {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Group name must not be a number or \"none\""})
+	}
+	if findGroup(database.DB, req.Name) != nil {
+		return c.JSON(http.StatusConflict, map[string]string{"error": "Group already exists"})
+	}
+
+	group := models.Group{Name: req.Name, Description: strings.TrimSpace(req.Description)}
+	if err := database.DB.Create(&group).Error; err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	return c.JSON(http.StatusCreated, group)
+}
+
+func (h *PeerHandler) UpdateGroup(c echo.Context) error {
+	var group models.Group
+	if err := database.DB.First(&group, c.Param("id")).Error; err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Group not found"})
+	}
+
+	var req struct {
+		Name        *string `json:"name"`
+		Description *string `json:"description"`
+	}
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
+	}
+	if req.Name != nil {
+		name := strings.TrimSpace(*req.Name)
+		if name == "" {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Name cannot be empty"})
+		}
+		if _, err := strconv.ParseUint(name, 10, 64); err == nil || name == groupNone {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Group name must not be a number or \"none\""})
+		}
+		if other := findGroup(database.DB, name); other != nil && other.ID != group.ID {
+			return c.JSON(http.StatusConflict, map[string]string{"error": "Group already exists"})
+		}
+		group.Name = name
+	}
+	if req.Description != nil {
+		group.Description = strings.TrimSpace(*req.Description)
+	}
+
+	if err := database.DB.Save(&group).Error; err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	return c.JSON(http.StatusOK, group)
+}
+
+// DeleteGroup xoá group; peer và port forward của group vẫn giữ nguyên, chỉ bỏ liên kết.
+// Group còn được rule ACL tham chiếu thì không xoá được.
+func (h *PeerHandler) DeleteGroup(c echo.Context) error {
+	// Kiểm tra rule ACL và xoá trong cùng transaction (chặn thay đổi ACL) để rule mới không trỏ tới group đã xoá
+	err := h.peerSvc.Exclusive(func(tx *gorm.DB) error {
+		var group models.Group
+		if err := tx.First(&group, c.Param("id")).Error; err != nil {
+			return &requestError{http.StatusNotFound, "Group not found"}
+		}
+
+		// Bỏ group khỏi rule ACL sẽ đổi ý nghĩa của rule, cần sửa/xoá rule trước
+		var rules int64
+		groupID := strconv.FormatUint(uint64(group.ID), 10)
+		if err := tx.Model(&models.ACLRule{}).Where("(source_type = ? AND source = ?) OR (dest_type = ? AND dest = ?)", models.ACLTargetGroup, groupID, models.ACLTargetGroup, groupID).Count(&rules).Error; err != nil {
+			return err
+		}
+		if rules > 0 {
+			return &requestError{http.StatusConflict, fmt.Sprintf("Group is used by %d access control rules", rules)}
+		}
+
+		for _, model := range []interface{}{&models.Peer{}, &models.PortForward{}} {
+			if err := tx.Unscoped().Model(model).Where("group_id = ?", group.ID).Update("group_id", nil).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Delete(&group).Error
+	})
+	if err != nil {
+		return peerChangeError(c, err)
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// groupNone chọn các peer không thuộc group nào (?group=none)
+const groupNone = "none"
+
+// findGroup tìm group theo ID hoặc tên
+func findGroup(db *gorm.DB, selector string) *models.Group {
+	var group models.Group
+	query := db.Where("name = ?", selector)
+	if id, err := strconv.ParseUint(selector, 10, 64); err == nil {
+		query = db.Where("id = ?", id)
+	}
+	if res := query.Limit(1).Find(&group); res.Error != nil || res.RowsAffected == 0 {
+		return nil
+	}
+	return &group
+}
+
+// peerSelector chọn một tập peer theo mạng, group, tag và/hoặc danh sách ID
+type peerSelector struct {
+	Group string   `json:"group"` // ID, tên hoặc "none"
+	Tags  []string `json:"tags"`  // Peer phải có đủ mọi tag
+	IDs   []uint   `json:"ids"`
+}
+
+func (sel peerSelector) empty() bool {
+	return sel.Group == "" && len(sel.Tags) == 0 && len(sel.IDs) == 0
+}
+
+// selectorFromQuery đọc ?group=, ?tag=a,b (hoặc lặp lại ?tag=) và ?ids=1,2
+func selectorFromQuery(c echo.Context) (peerSelector, error) {
+	sel := peerSelector{Group: c.QueryParam("group")}
+	for _, raw := range c.QueryParams()["tag"] {
+		sel.Tags = append(sel.Tags, splitCSV(raw)...)
+	}
+	for _, raw := range splitCSV(c.QueryParam("ids")) {
+		id, err := strconv.ParseUint(raw, 10, 64)
+		if err != nil {
+			return sel, fmt.Errorf("invalid peer id %q", raw)
+		}
+		sel.IDs = append(sel.IDs, uint(id))
+	}
+	return sel, nil
+}
+
+// filterByGroup lọc bản ghi có group_id (peer, port forward) theo ID/tên group hoặc "none"
+func filterByGroup(query *gorm.DB, selector string) (*gorm.DB, error) {
+	switch selector {
+	case "":
+		return query, nil
+	case groupNone:
+		return query.Where("group_id IS NULL"), nil
+	}
+	// Group được tìm trên cùng kết nối (có thể là transaction) nhưng không kèm điều kiện của query
+	group := findGroup(query.Session(&gorm.Session{NewDB: true}), selector)
+	if group == nil {
+		return nil, fmt.Errorf("group %q not found", selector)
+	}
+	return query.Where("group_id = ?", group.ID), nil
+}
+
+// applyPeerSelector lọc truy vấn peer theo selector
+func applyPeerSelector(query *gorm.DB, sel peerSelector) (*gorm.DB, error) {
+	query, err := filterByGroup(query, sel.Group)
+	if err != nil {
+		return nil, err
+	}
+	for _, tag := range normalizeTags(sel.Tags) {
+		query = query.Where("EXISTS (SELECT 1 FROM json_each(peers.tags) WHERE json_each.value = ?)", tag)
+	}
+	if len(sel.IDs) > 0 {
+		query = query.Where("id IN ?", sel.IDs)
+	}
+	return query, nil
+}
+
+// selectPeers trả về các peer khớp selector trong phạm vi mạng của request (?network=), đọc từ db.
+// Selector không hợp lệ (mạng/group không tồn tại) trả về lỗi 400.
+func (h *PeerHandler) selectPeers(db *gorm.DB, c echo.Context, sel peerSelector) ([]models.Peer, error) {
+	query, err := h.networkScopeOn(db, c)
+	if err != nil {
+		return nil, badRequest("%v", err)
+	}
+	if query, err = applyPeerSelector(query, sel); err != nil {
+		return nil, badRequest("%v", err)
+	}
+	var peers []models.Peer
+	err = query.Order("id").Find(&peers).Error
+	return peers, err
+}
+
+// BulkPeers thực hiện enable/disable/delete cho mọi peer khớp selector, vd. cả một group
+func (h *PeerHandler) BulkPeers(c echo.Context) error {
+	var req struct {
+		peerSelector
+		Action string `json:"action"` // enable, disable, delete
+	}
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
+	}
+	if req.peerSelector.empty() {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Select peers by group, tags or ids"})
+	}
+
+	// Peer được chọn trong transaction áp dụng thay đổi để không ghi đè sửa/xoá đồng thời
+	load := func(tx *gorm.DB) ([]models.Peer, error) {
+		return h.selectPeers(tx, c, req.peerSelector)
+	}
+
+	var peers []models.Peer
+	var err error
+	switch req.Action {
+	case "enable":
+		peers, err = h.peerSvc.SetEnabled(load, true, "")
+	case "disable":
+		peers, err = h.peerSvc.SetEnabled(load, false, "")
+	case "delete":
+		peers, err = h.peerSvc.DeleteMany(load)
+	default:
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "action must be enable, disable or delete"})
+	}
+	if err != nil {
+		return peerChangeError(c, err)
+	}
+
+	ids := make([]uint, len(peers))
+	for i, p := range peers {
+		ids[i] = p.ID
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{"action": req.Action, "count": len(peers), "peer_ids": ids})
+}
+
+// DownloadPeerConfigs tải config của các peer khớp selector (?group=, ?tag=, ?ids=) dưới dạng ZIP
+func (h *PeerHandler) DownloadPeerConfigs(c echo.Context) error {
+	sel, err := selectorFromQuery(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	peers, err := h.selectPeers(database.DB, c, sel)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	if len(peers) == 0 {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "No peers match the selection"})
+	}
+
+	name := "wiretify-configs"
+	if sel.Group != "" {
+		name += "-" + safeFileName(sel.Group)
+	}
+	c.Response().Header().Set(echo.HeaderContentDisposition, fmt.Sprintf("attachment; filename=%s-%s.zip", name, time.Now().Format("20060102")))
+	c.Response().Header().Set(echo.HeaderContentType, "application/zip")
+	c.Response().WriteHeader(http.StatusOK)
+
+	zw := zip.NewWriter(c.Response())
+	for _, peer := range peers {
+		conf, err := h.buildPeerConfig(peer)
+		if err != nil {
+			continue
+		}
+		w, err := zw.Create(safeFileName(peer.Name) + ".conf")
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write([]byte(conf)); err != nil {
+			return err
+		}
+		markConfigDelivered(peer)
+	}
+	return zw.Close()
+}
+
+var unsafeFileChars = regexp.MustCompile(`[^a-zA-Z0-9_.-]+`)
+
+// safeFileName thay các ký tự không an toàn cho tên file trong ZIP/header
+func safeFileName(name string) string {
+	name = strings.Trim(unsafeFileChars.ReplaceAllString(name, "_"), "_.")
+	if name == "" {
+		name = "peer"
+	}
+	return name
+}
+
+// normalizeTags bỏ khoảng trắng, tag rỗng và tag trùng
+func normalizeTags(tags []string) []string {
+	seen := make(map[string]bool, len(tags))
+	result := make([]string, 0, len(tags))
+	for _, t := range tags {
+		t = strings.TrimSpace(t)
+		if t == "" || seen[t] {
+			continue
+		}
+		seen[t] = true
+		result = append(result, t)
+	}
+	return result
+}
+
+func splitCSV(raw string) []string {
+	var items []string
+	for _, item := range strings.Split(raw, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// groupIDFromRequest kiểm tra group_id gửi lên; 0 nghĩa là bỏ group
+func groupIDFromRequest(db *gorm.DB, id *uint) (*uint, error) {
+	if id == nil || *id == 0 {
+		return nil, nil
+	}
+	if err := db.First(&models.Group{}, *id).Error; err != nil {
+		return nil, fmt.Errorf("Group not found")
+	}
+	return id, nil
+}
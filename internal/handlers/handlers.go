@@ -1,11 +1,12 @@
 package handlers
 
 import (
-	"encoding/binary"
+	"errors"
 	"fmt"
-	"net"
 	"net/http"
+	"net/netip"
 	"os"
+	"strconv"
 	"strings"
 	"time"
 	"wiretify/internal/config"
@@ -14,22 +15,27 @@ import (
 	"wiretify/internal/services"
 
 	"github.com/labstack/echo/v4"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+	"gorm.io/gorm"
 )
 
 type PeerHandler struct {
-	wgSvc  *services.WGService
-	netSvc *services.NetworkService
-	domSvc *services.DomainService
-	cfg    *config.Config
+	networks *services.NetworkManager
+	domSvc   *services.DomainService
+	peerSvc  *services.PeerService
+	statsSvc *services.StatsSampler
+	watcher  *services.PeerWatcher
+	cfg      *config.Config
 }
 
-func RegisterRoutes(e *echo.Echo, api *echo.Group, wgSvc *services.WGService, netSvc *services.NetworkService, domSvc *services.DomainService, cfg *config.Config) {
-	h := &PeerHandler{wgSvc: wgSvc, netSvc: netSvc, domSvc: domSvc, cfg: cfg}
+func RegisterRoutes(e *echo.Echo, api *echo.Group, networks *services.NetworkManager, domSvc *services.DomainService, peerSvc *services.PeerService, statsSvc *services.StatsSampler, watcher *services.PeerWatcher, cfg *config.Config) {
+	h := &PeerHandler{networks: networks, domSvc: domSvc, peerSvc: peerSvc, statsSvc: statsSvc, watcher: watcher, cfg: cfg}
 
 	// Public Routes
 	e.GET("/login", h.ShowLogin)
 	e.POST("/login", h.PostLogin)
 	e.GET("/logout", h.Logout)
+	e.GET("/share/:token", h.OpenShareLink) // Chỉ bỏ qua auth khi token còn hiệu lực
 
 	// Auth Middleware for all other routes
 	e.Use(h.AuthMiddleware)
@@ -40,12 +46,71 @@ func RegisterRoutes(e *echo.Echo, api *echo.Group, wgSvc *services.WGService, ne
 	e.GET("/domains", h.RenderDomains)
 	e.GET("/endpoints", h.RenderEndpoints)
 	e.GET("/access-control", h.RenderAccessControl)
+	e.GET("/mesh", h.RenderMesh)
 
 	// API Peer routes
 	api.GET("/peers", h.ListPeers)
 	api.POST("/peers", h.CreatePeer)
 	api.GET("/peers/:id/config", h.GetPeerConfig)
+	api.GET("/peers/:id/config.png", h.GetPeerConfigPNG)
+	api.GET("/peers/:id/config.svg", h.GetPeerConfigSVG)
+	api.POST("/peers/:id/share", h.CreateShareLink)
+	api.PUT("/peers/:id", h.UpdatePeer)
+	api.PATCH("/peers/:id", h.UpdatePeer)
 	api.DELETE("/peers/:id", h.DeletePeer)
+	api.POST("/peers/sync", h.SyncPeers)
+	api.POST("/peers/import", h.ImportWGQuick)
+	api.POST("/peers/bulk", h.BulkPeers)
+	api.POST("/peers/provision", h.ProvisionPeers)
+	api.GET("/peers/configs.zip", h.DownloadPeerConfigs)
+	api.POST("/peers/:id/psk/rotate", h.RotatePresharedKey)
+	api.POST("/peers/:id/keys/rotate", h.RotatePeerKeys)
+	api.POST("/peers/:id/extend", h.ExtendPeer)
+	api.PUT("/peers/:id/quota", h.SetPeerQuota)
+	api.POST("/peers/:id/usage/reset", h.ResetPeerUsage)
+	api.GET("/peers/:id/events", h.ListPeerEvents)
+	api.GET("/peers/:id/stats", h.GetPeerStats)
+	api.GET("/events", h.StreamEvents)
+	api.GET("/peer-events", h.SearchPeerEvents)
+
+	// API Group routes
+	api.GET("/groups", h.ListGroups)
+	api.POST("/groups", h.CreateGroup)
+	api.PUT("/groups/:id", h.UpdateGroup)
+	api.DELETE("/groups/:id", h.DeleteGroup)
+
+	// API Network routes
+	api.GET("/networks", h.ListNetworks)
+	api.POST("/networks", h.CreateNetwork)
+	api.DELETE("/networks/:id", h.DeleteNetwork)
+
+	// API Server routes
+	api.GET("/server", h.GetServerInfo)
+	api.POST("/server/rotate-key", h.RotateServerKey)
+
+	// API Export routes
+	api.GET("/export/wg-quick", h.ExportWGQuick)
+	api.GET("/export/snapshot", h.ExportSnapshot)
+
+	// API Address pool routes
+	api.GET("/address-pool", h.GetAddressPool)
+	api.GET("/mesh", h.GetMeshTopology)
+	api.GET("/reservations", h.ListReservations)
+	api.POST("/reservations", h.CreateReservation)
+	api.DELETE("/reservations/:id", h.DeleteReservation)
+
+	// API Access control routes
+	api.GET("/acl", h.GetACL)
+	api.PUT("/acl/mode", h.SetACLMode)
+	api.POST("/acl/rules", h.CreateACLRule)
+	api.PUT("/acl/rules/:id", h.UpdateACLRule)
+	api.DELETE("/acl/rules/:id", h.DeleteACLRule)
+
+	// API Profile routes
+	api.GET("/profiles", h.ListProfiles)
+	api.POST("/profiles", h.CreateProfile)
+	api.PUT("/profiles/:id", h.UpdateProfile)
+	api.DELETE("/profiles/:id", h.DeleteProfile)
 
 	// API Port forward routes
 	api.GET("/portforwards", h.ListPortForwards)
@@ -74,8 +139,16 @@ func (h *PeerHandler) AuthMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
 			return next(c)
 		}
 
+		// Link chia sẻ config: chỉ cho qua khi token hợp lệ, chưa dùng và chưa hết hạn
+		if path == "/share/:token" {
+			if !isValidShareLink(c.Param("token")) {
+				return c.JSON(http.StatusNotFound, map[string]string{"error": "Link has expired or was already used"})
+			}
+			return next(c)
+		}
+
 		cookie, err := c.Cookie("session")
-		// For simplicity, we compare context to the password. 
+		// For simplicity, we compare context to the password.
 		// In production, use a proper session store or JWT.
 		if err != nil || cookie.Value != h.cfg.AdminPassword || h.cfg.AdminPassword == "" {
 			if strings.HasPrefix(path, "/api/") {
@@ -161,24 +234,37 @@ func (h *PeerHandler) ChangePassword(c echo.Context) error {
 }
 
 func (h *PeerHandler) ListPeers(c echo.Context) error {
+	query, err := h.networkScope(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	sel, err := selectorFromQuery(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	if query, err = applyPeerSelector(query, sel); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
 	var peers []models.Peer
-	if err := database.DB.Find(&peers).Error; err != nil {
+	if err := query.Find(&peers).Error; err != nil {
 		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
 	}
 
-	// Fetch active stats from WireGuard kernel
-	wgPeers, err := h.wgSvc.GetDevicePeers()
+	// Dùng snapshot của watcher nếu có, chỉ đọc kernel khi watcher chưa poll lần nào
+	wgPeers := h.watcher.Snapshot()
+	if wgPeers == nil {
+		wgPeers, err = h.networks.DevicePeers()
+	}
 	if err == nil {
+		now := time.Now()
 		for i := range peers {
 			if wgp, ok := wgPeers[peers[i].PublicKey]; ok {
 				peers[i].RxBytes = wgp.ReceiveBytes
 				peers[i].TxBytes = wgp.TransmitBytes
 				peers[i].LastHandshake = wgp.LastHandshakeTime
-
-				// A peer is considered "Connected" if the last handshake was within the last 3 minutes
-				if !wgp.LastHandshakeTime.IsZero() && time.Since(wgp.LastHandshakeTime) < 3*time.Minute {
-					peers[i].Connected = true
-				}
+				peers[i].Connected = services.IsConnected(wgp.LastHandshakeTime, now, h.cfg.HandshakeTimeout)
 			}
 		}
 	}
@@ -188,47 +274,163 @@ func (h *PeerHandler) ListPeers(c echo.Context) error {
 
 func (h *PeerHandler) CreatePeer(c echo.Context) error {
 	var req struct {
-		Name          string `json:"name"`
-		UseAsExitNode bool   `json:"use_as_exit_node"`
-		Icon          string `json:"icon"`
+		Name          string     `json:"name"`
+		UseAsExitNode bool       `json:"use_as_exit_node"`
+		Icon          string     `json:"icon"`
+		PublicKey     string     `json:"public_key"`     // Optional: key được sinh trên thiết bị, server không giữ private key
+		AllowedIPs    string     `json:"allowed_ips"`    // Optional: IP tĩnh trong subnet, mặc định cấp IP trống đầu tiên
+		AllowedIPs6   string     `json:"allowed_ips6"`   // Optional: IPv6 tĩnh khi mạng có IPv6
+		PresharedKey  *bool      `json:"preshared_key"`  // Optional: mặc định theo WG_PRESHARED_KEYS
+		RoutedSubnets []string   `json:"routed_subnets"` // Optional: LAN phía sau peer (site-to-site)
+		Mesh          bool       `json:"mesh"`           // Optional: kết nối trực tiếp với các mesh member khác
+		MeshEndpoint  string     `json:"mesh_endpoint"`  // Optional: host:port cố định của peer cho mesh
+		MeshPort      int        `json:"mesh_port"`      // Optional: ListenPort của peer, mặc định 51820 khi bật mesh
+		ProfileID     *uint      `json:"profile_id"`     // Optional: profile cấu hình client
+		GroupID       *uint      `json:"group_id"`       // Optional: group của peer
+		Tags          []string   `json:"tags"`           // Optional: tag tự do
+		ExpiresAt     *time.Time `json:"expires_at"`     // Optional: thời điểm hết hạn (RFC3339)
+		ExpiresIn     string     `json:"expires_in"`     // Optional: thời hạn tính từ bây giờ, vd. "720h"
 	}
 	if err := c.Bind(&req); err != nil {
 		return err
 	}
 
-	// Calculate next available IP
-	var allPeers []models.Peer
-	database.DB.Find(&allPeers)
+	expiresAt, err := resolveExpiry(req.ExpiresAt, req.ExpiresIn, time.Now())
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	if req.ProfileID != nil {
+		if err := database.DB.First(&models.Profile{}, *req.ProfileID).Error; err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Profile not found"})
+		}
+	}
+
+	groupID, err := groupIDFromRequest(database.DB, req.GroupID)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	meshEndpoint, err := validateMeshEndpoint(req.MeshEndpoint)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	meshPort, err := meshListenPort(req.Mesh, req.MeshPort)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
 
-	serverAddr := h.wgSvc.GetServerAddress()
-	nextIP, err := allocateNextIP(serverAddr, allPeers)
+	// Bring-your-own key: validate trước khi cấp phát IP
+	wn, err := h.selectedNetwork(c)
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "IP Allocation failed: " + err.Error()})
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
 	}
 
-	// Generate keys if not provided (Simplification: app always gens for convenience)
-	priv, pub, err := h.wgSvc.GenerateKeyPair()
+	clientPubKey, status, err := h.parseClientPublicKey(req.PublicKey)
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to generate keys"})
+		return c.JSON(status, map[string]string{"error": err.Error()})
+	}
+
+	// Generate keys if not provided
+	priv, pub := "", clientPubKey
+	if pub == "" {
+		priv, pub, err = services.GenerateKeyPair()
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to generate keys"})
+		}
+	}
+
+	usePSK := h.cfg.PresharedKeys
+	if req.PresharedKey != nil {
+		usePSK = *req.PresharedKey
+	}
+
+	var psk string
+	if usePSK {
+		psk, err = services.GeneratePresharedKey()
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to generate preshared key"})
+		}
 	}
 
 	peer := models.Peer{
+		NetworkID:     wn.ID,
 		Name:          req.Name,
 		PublicKey:     pub,
 		PrivateKey:    priv,
-		AllowedIPs:    nextIP,
+		PresharedKey:  psk,
+		ProfileID:     req.ProfileID,
+		GroupID:       groupID,
+		Tags:          normalizeTags(req.Tags),
+		ExpiresAt:     expiresAt,
 		UseAsExitNode: req.UseAsExitNode,
 		Enabled:       true,
 		Icon:          req.Icon,
+		Mesh:          req.Mesh,
+		MeshEndpoint:  meshEndpoint,
+		MeshPort:      meshPort,
 	}
 
-	if err := database.DB.Create(&peer).Error; err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
-	}
+	// Cấp IP và lưu trong transaction của PeerService để hai request song song không chọn trùng IP;
+	// peer chỉ được lưu nếu áp dụng vào kernel thành công
+	err = h.peerSvc.Transaction(wn.ID, func(tx *gorm.DB) error {
+		// Calculate next available IP (subnet các mạng không chồng nhau nên xét mọi peer là đủ)
+		var allPeers []models.Peer
+		if err := tx.Find(&allPeers).Error; err != nil {
+			return err
+		}
 
-	// Sync to kernel (reload peers from DB to include the new one)
-	database.DB.Find(&allPeers)
-	h.wgSvc.SyncPeers(allPeers)
+		routedSubnets, err := validateRoutedSubnets(req.RoutedSubnets, h.networks.Pools(), allPeers)
+		if err != nil {
+			return badRequest("Invalid routed_subnets: %v", err)
+		}
+
+		// IP tĩnh được phép nằm trong reservation; IP tự cấp thì không
+		reserved := loadReservations(tx, wn.ID)
+		var nextIP string
+		if req.AllowedIPs != "" {
+			if nextIP, err = services.ValidatePeerAddress(req.AllowedIPs, wn.Address, allPeers); err != nil {
+				return badRequest("Invalid allowed_ips: %v", err)
+			}
+		} else if nextIP, err = allocateNextIP(wn.Address, allPeers, reserved); err != nil {
+			return &requestError{http.StatusInternalServerError, "IP Allocation failed: " + err.Error()}
+		}
+
+		// Dual-stack: cấp thêm một địa chỉ IPv6 /128 nếu mạng có địa chỉ IPv6
+		var nextIP6 string
+		if req.AllowedIPs6 != "" && wn.Address6 == "" {
+			return badRequest("IPv6 is not enabled on this network")
+		}
+		if req.AllowedIPs6 != "" {
+			if nextIP6, err = services.ValidatePeerAddress(req.AllowedIPs6, wn.Address6, allPeers); err != nil {
+				return badRequest("Invalid allowed_ips6: %v", err)
+			}
+		} else if wn.Address6 != "" {
+			if nextIP6, err = allocateNextIP(wn.Address6, allPeers, reserved); err != nil {
+				return &requestError{http.StatusInternalServerError, "IPv6 Allocation failed: " + err.Error()}
+			}
+		}
+
+		peer.AllowedIPs, peer.AllowedIPs6, peer.RoutedSubnets = nextIP, nextIP6, routedSubnets
+		if err := tx.Create(&peer).Error; err != nil {
+			return err
+		}
+		// use_as_exit_node có default:true nên giá trị false bị bỏ qua khi Create
+		if !req.UseAsExitNode {
+			if err := tx.Model(&peer).UpdateColumn("use_as_exit_node", false).Error; err != nil {
+				return err
+			}
+			peer.UseAsExitNode = false
+		}
+		// Các mesh member khác cần config mới có [Peer] tới peer này
+		if peer.Mesh {
+			return services.MarkMeshOutdated(tx, wn.ID, peer.ID)
+		}
+		return nil
+	})
+	if err != nil {
+		return peerChangeError(c, err)
+	}
 
 	return c.JSON(http.StatusCreated, peer)
 }
@@ -240,37 +442,246 @@ func (h *PeerHandler) DeletePeer(c echo.Context) error {
 		return c.JSON(http.StatusNotFound, map[string]string{"error": "Peer not found"})
 	}
 
-	// 1. Get Peer IP address (internal) e.g. "10.8.0.3" from "10.8.0.3/32"
-	peerIP := peer.AllowedIPs
-	if host, _, err := net.SplitHostPort(peer.AllowedIPs); err == nil {
-		peerIP = host
-	} else if ip, _, err := net.ParseCIDR(peer.AllowedIPs); err == nil {
-		peerIP = ip.String()
+	if err := h.peerSvc.Delete(peer, false); err != nil {
+		return peerChangeError(c, err)
 	}
 
-	// 2. Find and delete all port forwards for this peer
-	var pfs []models.PortForward
-	database.DB.Where("target_node = ?", peerIP).Find(&pfs)
-	for _, pf := range pfs {
-		// Remove from kernel
-		if err := h.netSvc.RemovePortForward(pf.PublicPort, pf.TargetNode, pf.TargetPort, pf.Protocol); err != nil {
-			fmt.Printf("Warning: failed to remove iptables rules for port forward %d during peer deletion: %v\n", pf.PublicPort, err)
+	return c.NoContent(http.StatusNoContent)
+}
+
+// UpdatePeer cập nhật thông tin peer (PUT/PATCH). Chỉ các field được gửi lên mới bị thay đổi.
+func (h *PeerHandler) UpdatePeer(c echo.Context) error {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Peer not found"})
+	}
+
+	var req struct {
+		Name          *string   `json:"name"`
+		Icon          *string   `json:"icon"`
+		Enabled       *bool     `json:"enabled"`
+		UseAsExitNode *bool     `json:"use_as_exit_node"`
+		AllowedIPs    *string   `json:"allowed_ips"`
+		AllowedIPs6   *string   `json:"allowed_ips6"`
+		RoutedSubnets *[]string `json:"routed_subnets"`
+		Mesh          *bool     `json:"mesh"`
+		MeshEndpoint  *string   `json:"mesh_endpoint"` // "" để dùng endpoint mà hub thấy
+		MeshPort      *int      `json:"mesh_port"`
+		ProfileID     *uint     `json:"profile_id"` // 0 để bỏ gán profile
+		GroupID       *uint     `json:"group_id"`   // 0 để bỏ khỏi group
+		Tags          *[]string `json:"tags"`
+	}
+	if err := c.Bind(&req); err != nil {
+		return err
+	}
+
+	// PeerService đọc lại peer trong transaction, lưu và áp dụng vào kernel; lỗi kernel sẽ rollback
+	peer, err := h.peerSvc.Update(uint(id), func(tx *gorm.DB, peer *models.Peer) error {
+		wn := h.networks.ForPeer(*peer)
+		var others []models.Peer
+		if err := tx.Where("id <> ?", peer.ID).Find(&others).Error; err != nil {
+			return err
+		}
+
+		if req.Name != nil {
+			name := strings.TrimSpace(*req.Name)
+			if name == "" {
+				return badRequest("Name cannot be empty")
+			}
+			for _, o := range others {
+				if o.NetworkID == peer.NetworkID && o.Name == name {
+					return &requestError{http.StatusConflict, "Name is already in use"}
+				}
+			}
+			peer.Name = name
+		}
+		if req.Icon != nil {
+			peer.Icon = *req.Icon
+		}
+		if req.Enabled != nil {
+			peer.Enabled = *req.Enabled
+			if peer.Enabled {
+				peer.DisabledReason = ""
+			}
+		}
+		if req.UseAsExitNode != nil {
+			peer.UseAsExitNode = *req.UseAsExitNode
+		}
+		if req.AllowedIPs != nil {
+			addr, err := services.ValidatePeerAddress(*req.AllowedIPs, wn.Address, others)
+			if err != nil {
+				return badRequest("Invalid allowed_ips: %v", err)
+			}
+			peer.AllowedIPs = addr
+		}
+		if req.AllowedIPs6 != nil {
+			if wn.Address6 == "" {
+				return badRequest("IPv6 is not enabled on this network")
+			}
+			addr, err := services.ValidatePeerAddress(*req.AllowedIPs6, wn.Address6, others)
+			if err != nil {
+				return badRequest("Invalid allowed_ips6: %v", err)
+			}
+			peer.AllowedIPs6 = addr
+		}
+
+		if req.ProfileID != nil {
+			if *req.ProfileID == 0 {
+				peer.ProfileID = nil
+			} else if err := tx.First(&models.Profile{}, *req.ProfileID).Error; err != nil {
+				return badRequest("Profile not found")
+			} else {
+				peer.ProfileID = req.ProfileID
+			}
+		}
+		if req.GroupID != nil {
+			groupID, err := groupIDFromRequest(tx, req.GroupID)
+			if err != nil {
+				return badRequest("%v", err)
+			}
+			peer.GroupID = groupID
+		}
+		if req.Tags != nil {
+			peer.Tags = normalizeTags(*req.Tags)
+		}
+		if req.RoutedSubnets != nil {
+			subnets, err := validateRoutedSubnets(*req.RoutedSubnets, h.networks.Pools(), others)
+			if err != nil {
+				return badRequest("Invalid routed_subnets: %v", err)
+			}
+			peer.RoutedSubnets = subnets
+		}
+
+		if req.Mesh != nil {
+			peer.Mesh = *req.Mesh
+		}
+		if req.MeshEndpoint != nil {
+			endpoint, err := validateMeshEndpoint(*req.MeshEndpoint)
+			if err != nil {
+				return badRequest("%v", err)
+			}
+			peer.MeshEndpoint = endpoint
 		}
-		// Remove from DB
-		database.DB.Delete(&pf)
+		if req.MeshPort != nil {
+			peer.MeshPort = *req.MeshPort
+		}
+		port, err := meshListenPort(peer.Mesh, peer.MeshPort)
+		if err != nil {
+			return badRequest("%v", err)
+		}
+		peer.MeshPort = port
+		return nil
+	})
+	if err != nil {
+		return peerChangeError(c, err)
 	}
 
-	// 3. Delete peer from DB
-	if err := database.DB.Delete(&peer).Error; err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	return c.JSON(http.StatusOK, peer)
+}
+
+// ExtendPeer gia hạn (hoặc bỏ hạn) cho peer. Peer đã bị tắt do hết hạn sẽ được bật lại
+// nếu hạn mới nằm trong tương lai.
+func (h *PeerHandler) ExtendPeer(c echo.Context) error {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Peer not found"})
+	}
+
+	var req struct {
+		ExpiresAt *time.Time `json:"expires_at"` // Hạn mới tuyệt đối
+		ExtendBy  string     `json:"extend_by"`  // Cộng thêm vào hạn hiện tại (hoặc từ bây giờ nếu đã hết hạn)
+		Never     bool       `json:"never"`      // Bỏ hạn
+	}
+	if err := c.Bind(&req); err != nil {
+		return err
 	}
 
-	// 4. Sync WireGuard kernel state (this removes the peer from wg device)
-	var remainingPeers []models.Peer
-	database.DB.Find(&remainingPeers)
-	h.wgSvc.SyncPeers(remainingPeers)
+	// Hạn hiện tại được đọc lại trong transaction để không cộng vào giá trị cũ
+	peer, err := h.peerSvc.Extend(uint(id), func(current *time.Time) (*time.Time, error) {
+		if req.Never {
+			return nil, nil
+		}
+		base := time.Now()
+		if current != nil && current.After(base) {
+			base = *current
+		}
+		expiresAt, err := resolveExpiry(req.ExpiresAt, req.ExtendBy, base)
+		if err != nil {
+			return nil, badRequest("%v", err)
+		}
+		if expiresAt == nil {
+			return nil, badRequest("One of expires_at, extend_by or never is required")
+		}
+		return expiresAt, nil
+	})
+	if err != nil {
+		return peerChangeError(c, err)
+	}
+	return c.JSON(http.StatusOK, peer)
+}
 
-	return c.NoContent(http.StatusNoContent)
+// resolveExpiry tính thời điểm hết hạn từ giá trị tuyệt đối hoặc một khoảng thời gian cộng vào base.
+// Trả về nil nếu không có giá trị nào được cung cấp.
+func resolveExpiry(at *time.Time, after string, base time.Time) (*time.Time, error) {
+	if at != nil && after != "" {
+		return nil, fmt.Errorf("Specify either an absolute expiry time or a duration, not both")
+	}
+	if at != nil {
+		if !at.After(time.Now()) {
+			return nil, fmt.Errorf("Expiry time must be in the future")
+		}
+		t := at.UTC()
+		return &t, nil
+	}
+	if after == "" {
+		return nil, nil
+	}
+	d, err := time.ParseDuration(after)
+	if err != nil || d <= 0 {
+		return nil, fmt.Errorf("Invalid duration %q", after)
+	}
+	t := base.Add(d).UTC()
+	return &t, nil
+}
+
+// RotatePresharedKey sinh PSK mới cho peer (hoặc bật PSK nếu peer chưa có) và áp dụng ngay vào kernel
+func (h *PeerHandler) RotatePresharedKey(c echo.Context) error {
+	id := c.Param("id")
+	var peer models.Peer
+	if err := database.DB.First(&peer, id).Error; err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Peer not found"})
+	}
+
+	psk, err := services.GeneratePresharedKey()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to generate preshared key"})
+	}
+
+	// Update qua struct để serializer mã hoá PSK (update bằng map/cột sẽ bỏ qua serializer)
+	peer.PresharedKey = psk
+	peer.ConfigOutdated = true
+	err = h.peerSvc.Transaction(peer.NetworkID, func(tx *gorm.DB) error {
+		return tx.Model(&peer).Select("preshared_key", "config_outdated").Updates(&peer).Error
+	})
+	if err != nil {
+		return peerChangeError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{"message": "Preshared key rotated, download the new config for this peer"})
+}
+
+// SyncPeers chạy reconciler thủ công và trả về các thay đổi đã áp dụng
+func (h *PeerHandler) SyncPeers(c echo.Context) error {
+	result, err := h.syncPeers()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	return c.JSON(http.StatusOK, result)
+}
+
+// syncPeers nạp lại toàn bộ peer từ DB và đối chiếu với kernel
+func (h *PeerHandler) syncPeers() (*services.SyncResult, error) {
+	return h.peerSvc.Sync()
 }
 
 // --- Domain Handlers ---
@@ -342,30 +753,37 @@ func (h *PeerHandler) VerifyDomain(c echo.Context) error {
 // --- Endpoint Handlers ---
 
 func (h *PeerHandler) ListEndpoints(c echo.Context) error {
+	query, err := h.networkScope(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
 	var endpoints []models.Endpoint
 	// Preload Peer and Domain info
-	database.DB.Preload("Peer").Preload("Domain").Find(&endpoints)
-	
+	query.Preload("Peer").Preload("Domain").Find(&endpoints)
+
 	// Format the full addresses for UI convenience
 	type resp struct {
 		ID          uint   `json:"id"`
+		NetworkID   uint   `json:"network_id"`
 		Subdomain   string `json:"subdomain"`
 		RootDomain  string `json:"root_domain"`
 		PeerName    string `json:"peer_name"`
 		FullAddress string `json:"full_address"`
 	}
-	
+
 	data := make([]resp, len(endpoints))
 	for i, ep := range endpoints {
 		data[i] = resp{
 			ID:          ep.ID,
+			NetworkID:   ep.NetworkID,
 			Subdomain:   ep.Subdomain,
 			RootDomain:  ep.Domain.Name,
 			PeerName:    ep.Peer.Name,
 			FullAddress: fmt.Sprintf("%s.%s", ep.Subdomain, ep.Domain.Name),
 		}
 	}
-	
+
 	return c.JSON(http.StatusOK, data)
 }
 
@@ -393,6 +811,12 @@ func (h *PeerHandler) CreateEndpoint(c echo.Context) error {
 		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Root domain must be Active to create endpoints"})
 	}
 
+	// Endpoint thuộc mạng của peer đích
+	var peer models.Peer
+	if err := database.DB.First(&peer, req.PeerID).Error; err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Peer not found"})
+	}
+
 	// 2. Kiểm tra trùng lặp subdomain trên cùng domain
 	var existing models.Endpoint
 	if err := database.DB.Where("domain_id = ? AND subdomain = ?", req.DomainID, subdomain).First(&existing).Error; err == nil {
@@ -400,6 +824,7 @@ func (h *PeerHandler) CreateEndpoint(c echo.Context) error {
 	}
 
 	endpoint := models.Endpoint{
+		NetworkID: peer.NetworkID,
 		PeerID:    req.PeerID,
 		DomainID:  req.DomainID,
 		Subdomain: subdomain,
@@ -421,8 +846,18 @@ func (h *PeerHandler) DeleteEndpoint(c echo.Context) error {
 // --- Port Forwarding Handlers ---
 
 func (h *PeerHandler) ListPortForwards(c echo.Context) error {
+	query, err := h.networkScope(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	// ?group= chọn các forward thuộc sở hữu của group
+	if query, err = filterByGroup(query, c.QueryParam("group")); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
 	var pfs []models.PortForward
-	if err := database.DB.Find(&pfs).Error; err != nil {
+	if err := query.Find(&pfs).Error; err != nil {
 		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
 	}
 	return c.JSON(http.StatusOK, pfs)
@@ -434,11 +869,17 @@ func (h *PeerHandler) CreatePortForward(c echo.Context) error {
 		TargetNode string `json:"target_node"`
 		TargetPort int    `json:"target_port"`
 		Protocol   string `json:"protocol"`
+		GroupID    *uint  `json:"group_id"` // Optional: group sở hữu forward
 	}
 	if err := c.Bind(&req); err != nil {
 		return err
 	}
 
+	groupID, err := groupIDFromRequest(database.DB, req.GroupID)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
 	// 1. Check if public port is already in use for this protocol
 	var existing models.PortForward
 	if err := database.DB.Where("public_port = ? AND protocol = ?", req.PublicPort, req.Protocol).First(&existing).Error; err == nil {
@@ -446,12 +887,19 @@ func (h *PeerHandler) CreatePortForward(c echo.Context) error {
 	}
 
 	// 2. Optional: Verify target node IP exists in our peers list
+	// Forward thuộc mạng của peer đích; IP nhập tay không khớp peer nào thì thuộc mạng đang chọn
+	wn, err := h.selectedNetwork(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
 	var peer models.Peer
-	if err := database.DB.Where("allowed_ips LIKE ?", req.TargetNode+"/%").First(&peer).Error; err != nil {
-		// Log but allow (maybe it's a manual entry)
+	if err := database.DB.Where("allowed_ips LIKE ?", req.TargetNode+"/%").First(&peer).Error; err == nil {
+		wn = h.networks.ForPeer(peer)
 	}
 
 	pf := models.PortForward{
+		NetworkID:  wn.ID,
+		GroupID:    groupID,
 		PublicPort: req.PublicPort,
 		TargetNode: req.TargetNode,
 		TargetPort: req.TargetPort,
@@ -462,7 +910,7 @@ func (h *PeerHandler) CreatePortForward(c echo.Context) error {
 		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
 	}
 
-	err := h.netSvc.AddPortForward(pf.PublicPort, pf.TargetNode, pf.TargetPort, pf.Protocol)
+	err = wn.Net.AddPortForward(pf.PublicPort, pf.TargetNode, pf.TargetPort, pf.Protocol)
 	if err != nil {
 		database.DB.Unscoped().Delete(&pf) // hard delete if kernel logic fails to avoid DB pollution
 		return c.JSON(http.StatusInternalServerError, map[string]string{"error": fmt.Sprintf("failed to configure iptables: %v", err)})
@@ -478,7 +926,7 @@ func (h *PeerHandler) DeletePortForward(c echo.Context) error {
 		return c.JSON(http.StatusNotFound, map[string]string{"error": "Port forward not found"})
 	}
 
-	if err := h.netSvc.RemovePortForward(pf.PublicPort, pf.TargetNode, pf.TargetPort, pf.Protocol); err != nil {
+	if err := h.networkOf(pf.NetworkID).Net.RemovePortForward(pf.PublicPort, pf.TargetNode, pf.TargetPort, pf.Protocol); err != nil {
 		// Log warning but continue deletion
 		fmt.Printf("Warning: failed to remove iptables rules for port forward %d: %v\n", pf.PublicPort, err)
 	}
@@ -494,37 +942,11 @@ func (h *PeerHandler) GetPeerConfig(c echo.Context) error {
 		return c.JSON(http.StatusNotFound, map[string]string{"error": "Peer not found"})
 	}
 
-	serverPubKey, endpoint, port := h.wgSvc.GetServerConfig()
-
-	// Logic chia /32 thành IP Address cho Interface
-	// Nếu AllowedIPs là `10.8.0.2/32`, ta có thể dùng trực tiếp hoặc chuyển thành `/24` tùy network design.
-	// Ở đây WireGuard client cài đặt Address cũng dùng dạng CIDR, nên dùng trực tiếp AllowedIPs là ok.
-
-	// Routing AllowedIPs for client config
-	allowedIPsClient := "0.0.0.0/0, ::/0"
-	if peer.UseAsExitNode {
-		// Use server IP network as subnet, e.g 10.8.0.0/24
-		serverAddr := h.wgSvc.GetServerAddress()
-		ip, ipnet, err := net.ParseCIDR(serverAddr)
-		if err == nil {
-			allowedIPsClient = ipnet.String()
-		} else {
-			// Fallback
-			allowedIPsClient = fmt.Sprintf("%s/24", ip.String())
-		}
+	confStr, err := h.buildPeerConfig(peer)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
 	}
-
-	configTpl := `[Interface]
-PrivateKey = %s
-Address = %s
-
-[Peer]
-PublicKey = %s
-Endpoint = %s:%d
-AllowedIPs = %s
-PersistentKeepalive = 25
-`
-	confStr := fmt.Sprintf(configTpl, peer.PrivateKey, peer.AllowedIPs, serverPubKey, endpoint, port, allowedIPsClient)
+	markConfigDelivered(peer)
 
 	// Set header for file download
 	c.Response().Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s.conf", peer.Name))
@@ -533,48 +955,155 @@ PersistentKeepalive = 25
 	return c.String(http.StatusOK, confStr)
 }
 
-// allocateNextIP tìm IP khả dụng tiếp theo trong subnet của server
-func allocateNextIP(baseCIDR string, peers []models.Peer) (string, error) {
-	ip, ipnet, err := net.ParseCIDR(baseCIDR)
+// allocateNextIP tìm IP khả dụng tiếp theo trong subnet của server (IPv4 hoặc IPv6),
+// bỏ qua các dải đã được reservation giữ lại
+func allocateNextIP(baseCIDR string, peers []models.Peer, reserved []addrRange) (string, error) {
+	prefix, err := netip.ParsePrefix(baseCIDR)
 	if err != nil {
 		return "", err
 	}
 
-	ip = ip.To4()
-	if ip == nil {
-		return "", fmt.Errorf("only IPv4 is supported")
-	}
-
-	startIP := binary.BigEndian.Uint32(ip)
-	mask := binary.BigEndian.Uint32(ipnet.Mask)
+	serverIP := prefix.Addr().Unmap()
+	network := prefix.Masked()
 
-	usedIPs := make(map[uint32]bool)
-	usedIPs[startIP] = true // Đánh dấu Server IP đã sử dụng
+	usedIPs := make(map[netip.Addr]bool)
+	usedIPs[serverIP] = true // Đánh dấu Server IP đã sử dụng
 
 	for _, p := range peers {
-		pip, _, err := net.ParseCIDR(p.AllowedIPs)
-		if err == nil {
-			pip = pip.To4()
-			if pip != nil {
-				usedIPs[binary.BigEndian.Uint32(pip)] = true
+		for _, cidr := range []string{p.AllowedIPs, p.AllowedIPs6} {
+			if pp, err := netip.ParsePrefix(cidr); err == nil {
+				usedIPs[pp.Addr().Unmap()] = true
 			}
 		}
 	}
 
-	network := startIP & mask
-	broadcast := network | ^mask
-
-	for i := network + 1; i < broadcast; i++ {
-		if !usedIPs[i] {
-			nextIP := make(net.IP, 4)
-			binary.BigEndian.PutUint32(nextIP, i)
-			return fmt.Sprintf("%s/32", nextIP.String()), nil
+	for ip := network.Addr().Next(); ip.IsValid() && network.Contains(ip); ip = ip.Next() {
+		// Bỏ qua địa chỉ broadcast của subnet IPv4
+		if ip.Is4() && !network.Contains(ip.Next()) {
+			break
+		}
+		if !usedIPs[ip] && !isReserved(ip, reserved) {
+			return netip.PrefixFrom(ip, ip.BitLen()).String(), nil
 		}
 	}
 
 	return "", fmt.Errorf("no available IPs in subnet")
 }
 
+func isReserved(ip netip.Addr, reserved []addrRange) bool {
+	for _, r := range reserved {
+		if r.contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// requestError là lỗi validate phát sinh bên trong một thay đổi peer, kèm HTTP status cần trả về
+type requestError struct {
+	status  int
+	message string
+}
+
+func (e *requestError) Error() string { return e.message }
+
+func badRequest(format string, args ...interface{}) error {
+	return &requestError{http.StatusBadRequest, fmt.Sprintf(format, args...)}
+}
+
+// peerChangeError trả response phù hợp cho lỗi từ PeerService: lỗi validate, trùng địa chỉ/tên/key
+// (unique constraint) hoặc lỗi áp dụng vào kernel (DB đã được rollback)
+func peerChangeError(c echo.Context, err error) error {
+	var reqErr *requestError
+	switch {
+	case errors.As(err, &reqErr):
+		return c.JSON(reqErr.status, map[string]string{"error": reqErr.message})
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Peer not found"})
+	case errors.Is(err, gorm.ErrDuplicatedKey):
+		return c.JSON(http.StatusConflict, map[string]string{"error": "Address, name or key is already used by another peer"})
+	}
+	return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+}
+
+// parseClientPublicKey kiểm tra public key do client cung cấp (rỗng nghĩa là server tự sinh)
+// và trả về HTTP status phù hợp khi không hợp lệ
+func (h *PeerHandler) parseClientPublicKey(raw string) (string, int, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return "", http.StatusOK, nil
+	}
+
+	key, err := wgtypes.ParseKey(raw)
+	if err != nil {
+		return "", http.StatusBadRequest, fmt.Errorf("Invalid public key: %v", err)
+	}
+	pub := key.String()
+
+	for _, wn := range h.networks.All() {
+		if wn.WG == nil {
+			continue
+		}
+		if serverPubKey, _, _ := wn.WG.GetServerConfig(); pub == serverPubKey {
+			return "", http.StatusBadRequest, fmt.Errorf("Public key must not be the server key")
+		}
+	}
+
+	// Unique index vẫn tính cả các peer đã soft-delete; key cũ đang overlap cũng không được dùng lại
+	var existing models.Peer
+	if err := database.DB.Unscoped().Where("public_key = ? OR previous_public_key = ?", pub, pub).First(&existing).Error; err == nil {
+		return "", http.StatusConflict, fmt.Errorf("Public key is already in use")
+	}
+	return pub, http.StatusOK, nil
+}
+
+// networkPools trả về các subnet VPN của một mạng (IPv4 và IPv6 nếu bật)
+func networkPools(wn *services.WGNetwork) []string {
+	pools := []string{wn.Address}
+	if wn.Address6 != "" {
+		pools = append(pools, wn.Address6)
+	}
+	return pools
+}
+
+// validateRoutedSubnets chuẩn hoá danh sách subnet LAN của một peer và từ chối các subnet
+// chồng lấn với VPN pool, với nhau hoặc với địa chỉ/subnet của peer khác.
+func validateRoutedSubnets(subnets []string, pools []string, others []models.Peer) ([]string, error) {
+	taken := make(map[netip.Prefix]string)
+	for _, pool := range pools {
+		if p, err := netip.ParsePrefix(pool); err == nil {
+			taken[p.Masked()] = "the VPN subnet"
+		}
+	}
+	for _, o := range others {
+		for _, cidr := range append([]string{o.AllowedIPs, o.AllowedIPs6}, o.RoutedSubnets...) {
+			if p, err := netip.ParsePrefix(cidr); err == nil {
+				taken[p.Masked()] = "peer " + o.Name
+			}
+		}
+	}
+
+	result := make([]string, 0, len(subnets))
+	for _, raw := range subnets {
+		p, err := netip.ParsePrefix(strings.TrimSpace(raw))
+		if err != nil {
+			return nil, err
+		}
+		p = p.Masked()
+		if p.Bits() == 0 {
+			return nil, fmt.Errorf("%s would route all traffic to this peer", p)
+		}
+		for other, owner := range taken {
+			if p.Overlaps(other) {
+				return nil, fmt.Errorf("%s overlaps %s (%s)", p, other, owner)
+			}
+		}
+		taken[p] = "another subnet in this request"
+		result = append(result, p.String())
+	}
+	return result, nil
+}
+
 // --- Front-end Page renderers ---
 
 func (h *PeerHandler) RenderDashboard(c echo.Context) error {
@@ -588,7 +1117,7 @@ func (h *PeerHandler) RenderDashboardPage(c echo.Context) error {
 }
 
 func (h *PeerHandler) RenderPortForwardConfig(c echo.Context) error {
-	_, endpoint, _ := h.wgSvc.GetServerConfig()
+	endpoint := h.cfg.ServerEndpoint
 	return c.Render(http.StatusOK, "port_forward.html", map[string]interface{}{
 		"CurrentPage": "ports",
 		"PublicIP":    endpoint,
@@ -0,0 +1,68 @@
+package handlers
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"wiretify/internal/services"
+
+	"github.com/labstack/echo/v4"
+)
+
+// maxImportSize giới hạn kích thước file wg-quick được upload
+const maxImportSize = 4 << 20
+
+// ImportWGQuick import các [Peer] từ một file wg-quick vào mạng được chọn (?network=).
+// Body là JSON {"config": "...", "adopt_key": bool, "dry_run": bool}
+// hoặc multipart form với file "config" và các field adopt_key, dry_run.
+func (h *PeerHandler) ImportWGQuick(c echo.Context) error {
+	wn, err := h.selectedNetwork(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	var req struct {
+		Config   string `json:"config"`
+		AdoptKey bool   `json:"adopt_key"`
+		DryRun   bool   `json:"dry_run"`
+	}
+	if strings.HasPrefix(c.Request().Header.Get(echo.HeaderContentType), echo.MIMEMultipartForm) {
+		file, err := c.FormFile("config")
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Missing config file"})
+		}
+		f, err := file.Open()
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+		}
+		defer f.Close()
+		data, err := io.ReadAll(io.LimitReader(f, maxImportSize))
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+		}
+		req.Config = string(data)
+		req.AdoptKey = c.FormValue("adopt_key") == "true"
+		req.DryRun = c.FormValue("dry_run") == "true"
+	} else if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
+	}
+	if strings.TrimSpace(req.Config) == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "config is required"})
+	}
+
+	conf, err := services.ParseWGQuick(strings.NewReader(req.Config))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid wg-quick config: " + err.Error()})
+	}
+
+	result, err := h.peerSvc.ImportWGQuick(wn.ID, conf, services.ImportOptions{AdoptKey: req.AdoptKey, DryRun: req.DryRun})
+	var importErr *services.ImportError
+	if errors.As(err, &importErr) {
+		err = badRequest("%s", importErr.Reason)
+	}
+	if err != nil {
+		return peerChangeError(c, err)
+	}
+	return c.JSON(http.StatusOK, result)
+}
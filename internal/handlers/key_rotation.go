@@ -0,0 +1,66 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+	"wiretify/internal/database"
+	"wiretify/internal/models"
+	"wiretify/internal/services"
+
+	"github.com/labstack/echo/v4"
+)
+
+const maxKeyOverlap = 7 * 24 * time.Hour
+
+// RotatePeerKeys sinh keypair mới cho peer (hoặc nhận public key từ thiết bị),
+// giữ nguyên IP, endpoint và port forward. overlap giữ key cũ hoạt động để thiết bị kịp tải config mới.
+func (h *PeerHandler) RotatePeerKeys(c echo.Context) error {
+	var peer models.Peer
+	if err := database.DB.First(&peer, c.Param("id")).Error; err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Peer not found"})
+	}
+
+	var req struct {
+		PublicKey string `json:"public_key"` // Optional: key mới được sinh trên thiết bị
+		Overlap   string `json:"overlap"`    // Optional: vd. "1h", mặc định gỡ key cũ ngay
+	}
+	if err := c.Bind(&req); err != nil {
+		return err
+	}
+
+	var overlap time.Duration
+	if req.Overlap != "" {
+		d, err := time.ParseDuration(req.Overlap)
+		if err != nil || d < 0 {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid overlap duration"})
+		}
+		if d > maxKeyOverlap {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Overlap must not exceed 168h"})
+		}
+		overlap = d
+	}
+
+	pub, status, err := h.parseClientPublicKey(req.PublicKey)
+	if err != nil {
+		return c.JSON(status, map[string]string{"error": err.Error()})
+	}
+	priv := ""
+	if pub == "" {
+		priv, pub, err = services.GenerateKeyPair()
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to generate keys"})
+		}
+	}
+
+	rotated, err := h.peerSvc.RotateKeys(peer.ID, pub, priv, overlap)
+	if err != nil {
+		return peerChangeError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"message":                 "Keys rotated, download the new config for this peer",
+		"public_key":              rotated.PublicKey,
+		"previous_public_key":     rotated.PreviousPublicKey,
+		"previous_key_expires_at": rotated.PreviousKeyExpiresAt,
+	})
+}
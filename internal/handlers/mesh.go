@@ -0,0 +1,72 @@
+package handlers
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"wiretify/internal/services"
+
+	"github.com/labstack/echo/v4"
+)
+
+// GetMeshTopology trả về topology của mạng được chọn (?network=): hub, các peer,
+// liên kết hub ↔ peer và liên kết giữa các mesh member (direct hoặc relayed qua hub)
+func (h *PeerHandler) GetMeshTopology(c echo.Context) error {
+	wn, err := h.selectedNetwork(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	topo, err := services.BuildMeshTopology(wn)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+
+	wgPeers := h.watcher.Snapshot()
+	if wgPeers == nil {
+		wgPeers, _ = h.networks.DevicePeers()
+	}
+	now := time.Now()
+	for i := range topo.Nodes {
+		if wgp, ok := wgPeers[topo.Nodes[i].PublicKey]; ok && !topo.Nodes[i].Hub {
+			topo.Nodes[i].Connected = services.IsConnected(wgp.LastHandshakeTime, now, h.cfg.HandshakeTimeout)
+		}
+	}
+	return c.JSON(http.StatusOK, topo)
+}
+
+func (h *PeerHandler) RenderMesh(c echo.Context) error {
+	return c.Render(http.StatusOK, "mesh.html", map[string]interface{}{
+		"CurrentPage": "mesh",
+	})
+}
+
+// validateMeshEndpoint kiểm tra endpoint cố định dạng host:port; rỗng nghĩa là dùng endpoint mà hub thấy
+func validateMeshEndpoint(raw string) (string, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return "", nil
+	}
+	host, port, err := net.SplitHostPort(raw)
+	if err != nil || host == "" {
+		return "", fmt.Errorf("Invalid mesh_endpoint %q, expected host:port", raw)
+	}
+	if p, err := strconv.Atoi(port); err != nil || p < 1 || p > 65535 {
+		return "", fmt.Errorf("Invalid mesh_endpoint port %q", port)
+	}
+	return net.JoinHostPort(host, port), nil
+}
+
+// meshListenPort kiểm tra ListenPort của mesh member; mesh member chưa có port dùng DefaultMeshPort
+func meshListenPort(mesh bool, port int) (int, error) {
+	if port < 0 || port > 65535 {
+		return 0, fmt.Errorf("Invalid mesh_port %d", port)
+	}
+	if mesh && port == 0 {
+		return services.DefaultMeshPort, nil
+	}
+	return port, nil
+}
@@ -0,0 +1,189 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"net/netip"
+	"regexp"
+	"strconv"
+	"strings"
+	"wiretify/internal/database"
+	"wiretify/internal/models"
+	"wiretify/internal/services"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+var interfaceNamePattern = regexp.MustCompile(`^[a-zA-Z0-9_.-]{1,15}$`)
+
+// selectedNetwork trả về mạng được chọn qua ?network=<id hoặc tên>, mặc định là mạng đầu tiên
+func (h *PeerHandler) selectedNetwork(c echo.Context) (*services.WGNetwork, error) {
+	selector := c.QueryParam("network")
+	if selector == "" {
+		return h.networks.Default(), nil
+	}
+	if id, err := strconv.ParseUint(selector, 10, 64); err == nil {
+		if wn := h.networks.Get(uint(id)); wn != nil {
+			return wn, nil
+		}
+	}
+	for _, wn := range h.networks.All() {
+		if wn.Name == selector {
+			return wn, nil
+		}
+	}
+	return nil, fmt.Errorf("network %q not found", selector)
+}
+
+// networkScope lọc truy vấn theo mạng khi request có ?network=, ngược lại trả về mọi mạng
+func (h *PeerHandler) networkScope(c echo.Context) (*gorm.DB, error) {
+	return h.networkScopeOn(database.DB, c)
+}
+
+// networkScopeOn giống networkScope nhưng truy vấn trên db (vd. transaction đang chạy)
+func (h *PeerHandler) networkScopeOn(db *gorm.DB, c echo.Context) (*gorm.DB, error) {
+	if c.QueryParam("network") == "" {
+		return db, nil
+	}
+	wn, err := h.selectedNetwork(c)
+	if err != nil {
+		return nil, err
+	}
+	return db.Where("network_id = ?", wn.ID), nil
+}
+
+// networkOf trả về mạng theo ID, mặc định nếu không còn tồn tại
+func (h *PeerHandler) networkOf(id uint) *services.WGNetwork {
+	if wn := h.networks.Get(id); wn != nil {
+		return wn
+	}
+	return h.networks.Default()
+}
+
+func (h *PeerHandler) ListNetworks(c echo.Context) error {
+	type networkInfo struct {
+		models.Network
+		PublicKey string `json:"public_key"`
+		Peers     int64  `json:"peers"`
+	}
+
+	var result []networkInfo
+	for _, wn := range h.networks.All() {
+		info := networkInfo{Network: wn.Network}
+		if wn.WG != nil {
+			info.PublicKey, _, _ = wn.WG.GetServerConfig()
+		}
+		database.DB.Model(&models.Peer{}).Where("network_id = ?", wn.ID).Count(&info.Peers)
+		result = append(result, info)
+	}
+	return c.JSON(http.StatusOK, result)
+}
+
+// CreateNetwork tạo một mạng mới với interface, subnet, port và server key riêng
+func (h *PeerHandler) CreateNetwork(c echo.Context) error {
+	var req struct {
+		Name          string `json:"name"`
+		InterfaceName string `json:"interface_name"`
+		Address       string `json:"address"`
+		Address6      string `json:"address6"`
+		ListenPort    int    `json:"listen_port"`
+		Endpoint      string `json:"endpoint"`
+	}
+	if err := c.Bind(&req); err != nil {
+		return err
+	}
+
+	network := models.Network{
+		Name:          strings.TrimSpace(req.Name),
+		InterfaceName: strings.TrimSpace(req.InterfaceName),
+		Address:       strings.TrimSpace(req.Address),
+		Address6:      strings.TrimSpace(req.Address6),
+		ListenPort:    req.ListenPort,
+		Endpoint:      strings.TrimSpace(req.Endpoint),
+	}
+	if err := h.validateNetwork(network); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	wn, err := h.networks.Add(&network)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	return c.JSON(http.StatusCreated, wn.Network)
+}
+
+func (h *PeerHandler) DeleteNetwork(c echo.Context) error {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Network not found"})
+	}
+	if err := h.peerSvc.RemoveNetwork(uint(id)); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// validateNetwork kiểm tra mạng mới không trùng tên, interface, port và subnet với mạng khác
+// hoặc với subnet LAN của peer
+func (h *PeerHandler) validateNetwork(n models.Network) error {
+	if n.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+	if !interfaceNamePattern.MatchString(n.InterfaceName) {
+		return fmt.Errorf("interface_name must be 1-15 characters of letters, digits, '_', '.' or '-'")
+	}
+	if n.ListenPort < 1 || n.ListenPort > 65535 {
+		return fmt.Errorf("listen_port must be between 1 and 65535")
+	}
+
+	var prefixes []netip.Prefix
+	for _, addr := range []struct {
+		value string
+		is4   bool
+	}{{n.Address, true}, {n.Address6, false}} {
+		if addr.value == "" {
+			if addr.is4 {
+				return fmt.Errorf("address is required")
+			}
+			continue
+		}
+		prefix, err := netip.ParsePrefix(addr.value)
+		if err != nil || prefix.Addr().Is4() != addr.is4 {
+			return fmt.Errorf("invalid address %s", addr.value)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+
+	var others []string
+	for _, wn := range h.networks.All() {
+		switch {
+		case wn.Name == n.Name:
+			return fmt.Errorf("name %s is already in use", n.Name)
+		case wn.InterfaceName == n.InterfaceName:
+			return fmt.Errorf("interface %s is already in use", n.InterfaceName)
+		case wn.ListenPort == n.ListenPort:
+			return fmt.Errorf("listen port %d is already in use", n.ListenPort)
+		}
+		others = append(others, networkPools(wn)...)
+	}
+
+	var peers []models.Peer
+	database.DB.Find(&peers)
+	for _, p := range peers {
+		others = append(others, p.RoutedSubnets...)
+	}
+
+	for _, cidr := range others {
+		other, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			continue
+		}
+		for _, prefix := range prefixes {
+			if prefix.Overlaps(other) {
+				return fmt.Errorf("address %s overlaps %s", prefix, cidr)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,152 @@
+package handlers
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"wiretify/internal/database"
+	"wiretify/internal/models"
+	"wiretify/internal/services"
+)
+
+// clientPrivateKeyPlaceholder thay cho private key trong config của peer tự mang public key
+const clientPrivateKeyPlaceholder = "<INSERT_YOUR_PRIVATE_KEY>"
+
+// peerProfile trả về profile được gán cho peer. Peer chưa gán profile dùng
+// full tunnel nếu là exit node, ngược lại chỉ route subnet VPN (split tunnel).
+func peerProfile(peer models.Peer) models.Profile {
+	if peer.ProfileID != nil {
+		var profile models.Profile
+		if err := database.DB.First(&profile, *peer.ProfileID).Error; err == nil {
+			return profile
+		}
+	}
+
+	mode := models.ProfileModeSplit
+	if peer.UseAsExitNode {
+		mode = models.ProfileModeFull
+	}
+	return models.Profile{Mode: mode, PersistentKeepalive: 25}
+}
+
+// markConfigDelivered xoá cờ config_outdated sau khi config mới đã được giao cho người dùng
+func markConfigDelivered(peer models.Peer) {
+	if peer.ConfigOutdated {
+		database.DB.Model(&peer).UpdateColumn("config_outdated", false)
+	}
+}
+
+// buildPeerConfig render file cấu hình wg-quick của peer theo profile của nó
+func (h *PeerHandler) buildPeerConfig(peer models.Peer) (string, error) {
+	profile := peerProfile(peer)
+	wn := h.networks.ForPeer(peer)
+	if wn.WG == nil {
+		return "", fmt.Errorf("WireGuard controller for %s is not available", wn.InterfaceName)
+	}
+	serverPubKey, endpoint, port := wn.WG.GetServerConfig()
+
+	// Peer tự quản lý key: xuất template để người dùng tự điền private key
+	privateKey := peer.PrivateKey
+	if privateKey == "" {
+		privateKey = clientPrivateKeyPlaceholder
+	}
+
+	address := []string{peer.AllowedIPs}
+	if peer.AllowedIPs6 != "" {
+		address = append(address, peer.AllowedIPs6)
+	}
+
+	hub := services.ClientPeer{
+		PublicKey:           serverPubKey,
+		PresharedKey:        peer.PresharedKey,
+		Endpoint:            net.JoinHostPort(endpoint, strconv.Itoa(port)),
+		AllowedIPs:          h.clientAllowedIPs(peer, wn, profile),
+		PersistentKeepalive: profile.PersistentKeepalive,
+	}
+	cc := services.ClientConfig{
+		PrivateKey: privateKey,
+		Address:    address,
+		DNS:        profile.DNS,
+		MTU:        profile.MTU,
+	}
+
+	if peer.Mesh {
+		cc.ListenPort = peer.MeshPort
+		direct := meshClientPeers(peer, profile.PersistentKeepalive)
+		if len(direct) > 0 {
+			hub.Name = wn.Name + " (hub)"
+			hub.AllowedIPs = withoutPrefixes(hub.AllowedIPs, direct)
+		}
+		cc.Peers = append([]services.ClientPeer{hub}, direct...)
+	} else {
+		cc.Peers = []services.ClientPeer{hub}
+	}
+	return services.RenderClientConfig(cc)
+}
+
+// meshClientPeers trả về [Peer] trực tiếp tới các mesh member khác của mạng.
+// Member mà cả hai phía đều chưa có endpoint không có [Peer] riêng, traffic tới nó đi qua hub.
+func meshClientPeers(peer models.Peer, keepalive int) []services.ClientPeer {
+	members, err := services.MeshMembers(database.DB, peer.NetworkID, peer.ID)
+	if err != nil {
+		return nil
+	}
+
+	var direct []services.ClientPeer
+	for _, m := range members {
+		if !services.MeshDirect(peer, m) {
+			continue
+		}
+		direct = append(direct, services.ClientPeer{
+			Name:                m.Name,
+			PublicKey:           m.PublicKey,
+			Endpoint:            services.MeshEndpoint(m),
+			AllowedIPs:          services.MeshAllowedIPs(m),
+			PersistentKeepalive: keepalive,
+		})
+	}
+	return direct
+}
+
+// withoutPrefixes bỏ khỏi AllowedIPs của hub các prefix đã được gán cho [Peer] trực tiếp,
+// vì WireGuard không cho hai peer cùng một prefix. Subnet VPN vẫn đi qua hub cho các peer không có [Peer] trực tiếp.
+func withoutPrefixes(allowed []string, direct []services.ClientPeer) []string {
+	taken := make(map[string]bool)
+	for _, p := range direct {
+		for _, prefix := range p.AllowedIPs {
+			taken[prefix] = true
+		}
+	}
+	result := make([]string, 0, len(allowed))
+	for _, prefix := range allowed {
+		if !taken[prefix] {
+			result = append(result, prefix)
+		}
+	}
+	return result
+}
+
+// clientAllowedIPs tính AllowedIPs phía client theo chế độ định tuyến của profile
+func (h *PeerHandler) clientAllowedIPs(peer models.Peer, wn *services.WGNetwork, profile models.Profile) []string {
+	switch profile.Mode {
+	case models.ProfileModeCustom:
+		return profile.Routes
+	case models.ProfileModeSplit:
+		var routes []string
+		for _, pool := range networkPools(wn) {
+			if _, ipnet, err := net.ParseCIDR(pool); err == nil {
+				routes = append(routes, ipnet.String())
+			}
+		}
+
+		// LAN của các site khác trong cùng mạng cũng được route qua VPN
+		var sites []models.Peer
+		database.DB.Where("id <> ? AND enabled = ? AND network_id = ?", peer.ID, true, wn.ID).Find(&sites)
+		for _, site := range sites {
+			routes = append(routes, site.RoutedSubnets...)
+		}
+		return routes
+	default:
+		return []string{"0.0.0.0/0", "::/0"}
+	}
+}
@@ -0,0 +1,90 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"wiretify/internal/database"
+	"wiretify/internal/models"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultEventLimit = 200
+	maxEventLimit     = 1000
+)
+
+// ListPeerEvents trả về các sự kiện gần nhất của peer
+func (h *PeerHandler) ListPeerEvents(c echo.Context) error {
+	query, err := peerEventQuery(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	var events []models.PeerEvent
+	if err := query.Where("peer_id = ?", c.Param("id")).Find(&events).Error; err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	return c.JSON(http.StatusOK, events)
+}
+
+// SearchPeerEvents tìm sự kiện của mọi peer, vd. peer nào đã kết nối từ một IP trong khoảng thời gian
+func (h *PeerHandler) SearchPeerEvents(c echo.Context) error {
+	query, err := peerEventQuery(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	if peerID := c.QueryParam("peer_id"); peerID != "" {
+		query = query.Where("peer_id = ?", peerID)
+	}
+
+	var events []models.PeerEvent
+	if err := query.Find(&events).Error; err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	return c.JSON(http.StatusOK, events)
+}
+
+// peerEventQuery dựng truy vấn từ các tham số lọc chung:
+// type (có thể nhiều, phân cách bằng dấu phẩy), ip, from, to (RFC3339), limit
+func peerEventQuery(c echo.Context) (*gorm.DB, error) {
+	query := database.DB.Order("created_at DESC")
+
+	if types := c.QueryParam("type"); types != "" {
+		query = query.Where("type IN ?", strings.Split(types, ","))
+	}
+	if ip := c.QueryParam("ip"); ip != "" {
+		query = query.Where("source_ip = ?", ip)
+	}
+	for _, bound := range []struct{ param, cond string }{
+		{"from", "created_at >= ?"},
+		{"to", "created_at < ?"},
+	} {
+		value := c.QueryParam(bound.param)
+		if value == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %v", bound.param, err)
+		}
+		query = query.Where(bound.cond, t.UTC())
+	}
+
+	limit := defaultEventLimit
+	if value := c.QueryParam("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("invalid limit")
+		}
+		if n > maxEventLimit {
+			n = maxEventLimit
+		}
+		limit = n
+	}
+	return query.Limit(limit), nil
+}
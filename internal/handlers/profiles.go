@@ -0,0 +1,141 @@
+package handlers
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"wiretify/internal/database"
+	"wiretify/internal/models"
+
+	"github.com/labstack/echo/v4"
+)
+
+// --- Profile Handlers ---
+
+type profileRequest struct {
+	Name                string   `json:"name"`
+	Mode                string   `json:"mode"`
+	Routes              []string `json:"routes"`
+	DNS                 []string `json:"dns"`
+	MTU                 int      `json:"mtu"`
+	PersistentKeepalive int      `json:"persistent_keepalive"`
+}
+
+func (h *PeerHandler) ListProfiles(c echo.Context) error {
+	var profiles []models.Profile
+	if err := database.DB.Find(&profiles).Error; err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	return c.JSON(http.StatusOK, profiles)
+}
+
+func (h *PeerHandler) CreateProfile(c echo.Context) error {
+	var req profileRequest
+	if err := c.Bind(&req); err != nil {
+		return err
+	}
+
+	var profile models.Profile
+	if err := applyProfileRequest(&profile, req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	if err := database.DB.Create(&profile).Error; err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	return c.JSON(http.StatusCreated, profile)
+}
+
+func (h *PeerHandler) UpdateProfile(c echo.Context) error {
+	id := c.Param("id")
+	var profile models.Profile
+	if err := database.DB.First(&profile, id).Error; err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Profile not found"})
+	}
+
+	var req profileRequest
+	if err := c.Bind(&req); err != nil {
+		return err
+	}
+	if err := applyProfileRequest(&profile, req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	if err := database.DB.Select("*").Save(&profile).Error; err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	return c.JSON(http.StatusOK, profile)
+}
+
+func (h *PeerHandler) DeleteProfile(c echo.Context) error {
+	id := c.Param("id")
+	var profile models.Profile
+	if err := database.DB.First(&profile, id).Error; err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Profile not found"})
+	}
+
+	var inUse int64
+	database.DB.Model(&models.Peer{}).Where("profile_id = ?", profile.ID).Count(&inUse)
+	if inUse > 0 {
+		return c.JSON(http.StatusConflict, map[string]string{"error": fmt.Sprintf("Profile is used by %d peer(s)", inUse)})
+	}
+
+	database.DB.Delete(&profile)
+	return c.NoContent(http.StatusNoContent)
+}
+
+// applyProfileRequest validate request và ghi các giá trị đã chuẩn hoá vào profile
+func applyProfileRequest(profile *models.Profile, req profileRequest) error {
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		return fmt.Errorf("Profile name is required")
+	}
+
+	var routes []string
+	switch req.Mode {
+	case models.ProfileModeFull, models.ProfileModeSplit:
+	case models.ProfileModeCustom:
+		if len(req.Routes) == 0 {
+			return fmt.Errorf("Custom profiles require at least one route")
+		}
+		for _, r := range req.Routes {
+			p, err := netip.ParsePrefix(strings.TrimSpace(r))
+			if err != nil {
+				return fmt.Errorf("Invalid route %q: %v", r, err)
+			}
+			routes = append(routes, p.Masked().String())
+		}
+	default:
+		return fmt.Errorf("Mode must be one of %s, %s, %s", models.ProfileModeFull, models.ProfileModeSplit, models.ProfileModeCustom)
+	}
+
+	var dns []string
+	for _, d := range req.DNS {
+		d = strings.TrimSpace(d)
+		if d == "" {
+			continue
+		}
+		// wg-quick chấp nhận cả IP DNS server lẫn search domain
+		if net.ParseIP(d) == nil && strings.ContainsAny(d, " ,/") {
+			return fmt.Errorf("Invalid DNS entry %q", d)
+		}
+		dns = append(dns, d)
+	}
+
+	if req.MTU != 0 && (req.MTU < 1280 || req.MTU > 9000) {
+		return fmt.Errorf("MTU must be between 1280 and 9000")
+	}
+	if req.PersistentKeepalive < 0 || req.PersistentKeepalive > 65535 {
+		return fmt.Errorf("Persistent keepalive must be between 0 and 65535 seconds")
+	}
+
+	profile.Name = name
+	profile.Mode = req.Mode
+	profile.Routes = routes
+	profile.DNS = dns
+	profile.MTU = req.MTU
+	profile.PersistentKeepalive = req.PersistentKeepalive
+	return nil
+}
@@ -0,0 +1,394 @@
+package handlers
+
+import (
+	"archive/zip"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"wiretify/internal/models"
+	"wiretify/internal/services"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+// maxProvisionRows giới hạn số peer trong một lần provision
+const maxProvisionRows = 1000
+
+// provisionRow là một dòng trong danh sách peer cần tạo (JSON hoặc CSV cùng tên cột)
+type provisionRow struct {
+	Name          string   `json:"name"`
+	Icon          string   `json:"icon"`
+	UseAsExitNode bool     `json:"use_as_exit_node"` // Mặc định false (split tunnel) như khi tạo từng peer
+	AllowedIPs    string   `json:"allowed_ips"`      // Optional: IP tĩnh
+	AllowedIPs6   string   `json:"allowed_ips6"`     // Optional: IPv6 tĩnh
+	Group         string   `json:"group"`            // Optional: ID hoặc tên group
+	Tags          []string `json:"tags"`
+
+	err error // Lỗi khi đọc dòng CSV
+}
+
+// provisionResult là kết quả của từng dòng, được trả về trong report.json
+type provisionResult struct {
+	Row         int    `json:"row"`
+	Name        string `json:"name"`
+	Status      string `json:"status"` // created, rejected
+	PeerID      uint   `json:"peer_id,omitempty"`
+	AllowedIPs  string `json:"allowed_ips,omitempty"`
+	AllowedIPs6 string `json:"allowed_ips6,omitempty"`
+	Files       string `json:"files,omitempty"`
+	Error       string `json:"error,omitempty"`
+}
+
+// ProvisionPeers tạo hàng loạt peer trong mạng được chọn (?network=).
+// Body là JSON [{"name": ..., ...}] hoặc {"peers": [...]}, CSV (text/csv) có dòng header,
+// hoặc multipart form với file "file". Địa chỉ được cấp phát trong một transaction và kernel
+// chỉ được sync một lần ở cuối. Trả về ZIP gồm config + QR code của mỗi peer và report.json;
+// dòng không hợp lệ bị bỏ qua và được ghi lỗi trong report.
+func (h *PeerHandler) ProvisionPeers(c echo.Context) error {
+	wn, err := h.selectedNetwork(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	rows, err := readProvisionRows(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	if len(rows) == 0 {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "No peers to provision"})
+	}
+	if len(rows) > maxProvisionRows {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("At most %d peers can be provisioned at once", maxProvisionRows)})
+	}
+
+	results := make([]provisionResult, len(rows))
+	var created []models.Peer
+	createdRows := make(map[uint]int)
+	// Toàn bộ batch được cấp IP trong một transaction của PeerService và áp dụng vào kernel một lần;
+	// nếu kernel lỗi thì không peer nào được tạo
+	err = h.peerSvc.Transaction(wn.ID, func(tx *gorm.DB) error {
+		// Subnet các mạng không chồng nhau nên xét mọi peer là đủ, như CreatePeer
+		var allPeers []models.Peer
+		if err := tx.Find(&allPeers).Error; err != nil {
+			return err
+		}
+		// Tên peer là unique trong mạng, kể cả với peer đã soft-delete
+		var names []string
+		if err := tx.Unscoped().Model(&models.Peer{}).Where("network_id = ?", wn.ID).Pluck("name", &names).Error; err != nil {
+			return err
+		}
+		usedNames := make(map[string]bool, len(names))
+		for _, n := range names {
+			usedNames[n] = true
+		}
+
+		var groups []models.Group
+		if err := tx.Find(&groups).Error; err != nil {
+			return err
+		}
+		groupIDs := make(map[string]uint, 2*len(groups))
+		for _, g := range groups {
+			groupIDs[g.Name] = g.ID
+			groupIDs[strconv.FormatUint(uint64(g.ID), 10)] = g.ID
+		}
+		reserved := loadReservations(tx, wn.ID)
+
+		// Dòng có IP tĩnh được xử lý trước để IP tự cấp không chiếm mất địa chỉ của chúng
+		order := make([]int, 0, len(rows))
+		for _, static := range []bool{true, false} {
+			for i, row := range rows {
+				if (row.AllowedIPs != "" || row.AllowedIPs6 != "") == static {
+					order = append(order, i)
+				}
+			}
+		}
+
+		for _, i := range order {
+			row := rows[i]
+			results[i] = provisionResult{Row: i + 1, Name: strings.TrimSpace(row.Name), Status: "rejected"}
+			peer, err := h.provisionPeer(wn, row, groupIDs, allPeers, reserved)
+			if err == nil && usedNames[peer.Name] {
+				err = fmt.Errorf("name %q is already in use", peer.Name)
+			}
+			if err != nil {
+				results[i].Error = err.Error()
+				continue
+			}
+			exitNode := peer.UseAsExitNode
+			if err := tx.Create(&peer).Error; err != nil {
+				return fmt.Errorf("row %d: %w", i+1, err)
+			}
+			// use_as_exit_node có default:true nên giá trị false bị bỏ qua khi Create
+			if !exitNode {
+				if err := tx.Model(&peer).UpdateColumn("use_as_exit_node", false).Error; err != nil {
+					return fmt.Errorf("row %d: %w", i+1, err)
+				}
+				peer.UseAsExitNode = false
+			}
+			usedNames[peer.Name] = true
+			allPeers = append(allPeers, peer)
+			created = append(created, peer)
+			createdRows[peer.ID] = i
+			results[i].Status = "created"
+			results[i].PeerID = peer.ID
+			results[i].AllowedIPs = peer.AllowedIPs
+			results[i].AllowedIPs6 = peer.AllowedIPs6
+		}
+		return nil
+	})
+	if err != nil {
+		return peerChangeError(c, err)
+	}
+	if len(created) == 0 {
+		return c.JSON(http.StatusUnprocessableEntity, map[string]interface{}{"error": "All rows were rejected", "results": results})
+	}
+
+	// Ghép file của mỗi peer vào ZIP theo thứ tự dòng; tên trùng được thêm ID peer
+	sort.Slice(created, func(i, j int) bool { return createdRows[created[i].ID] < createdRows[created[j].ID] })
+	files := make(map[uint]string, len(created))
+	used := make(map[string]bool, len(created))
+	for _, p := range created {
+		name := safeFileName(p.Name)
+		if used[name] {
+			name = fmt.Sprintf("%s-%d", name, p.ID)
+		}
+		used[name] = true
+		files[p.ID] = name
+	}
+	for id, name := range files {
+		results[createdRows[id]].Files = name + ".conf, " + name + ".png"
+	}
+
+	rejected := len(rows) - len(created)
+	c.Response().Header().Set(echo.HeaderContentDisposition, fmt.Sprintf("attachment; filename=wiretify-provision-%s.zip", time.Now().Format("20060102-150405")))
+	c.Response().Header().Set(echo.HeaderContentType, "application/zip")
+	c.Response().Header().Set("X-Provision-Created", strconv.Itoa(len(created)))
+	c.Response().Header().Set("X-Provision-Rejected", strconv.Itoa(rejected))
+	c.Response().WriteHeader(http.StatusCreated)
+
+	zw := zip.NewWriter(c.Response())
+	for _, p := range created {
+		conf, err := h.buildPeerConfig(p)
+		if err != nil {
+			log.Printf("Provision: failed to build config for %s: %v", p.Name, err)
+			continue
+		}
+		png, err := services.QRCodePNG(conf, 512)
+		if err != nil {
+			log.Printf("Provision: failed to render QR code for %s: %v", p.Name, err)
+		}
+		for _, f := range []struct {
+			ext  string
+			data []byte
+		}{{".conf", []byte(conf)}, {".png", png}} {
+			if len(f.data) == 0 {
+				continue
+			}
+			w, err := zw.Create(files[p.ID] + f.ext)
+			if err != nil {
+				return err
+			}
+			if _, err := w.Write(f.data); err != nil {
+				return err
+			}
+		}
+	}
+
+	w, err := zw.Create("report.json")
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(map[string]interface{}{"network_id": wn.ID, "created": len(created), "rejected": rejected, "results": results}); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// provisionPeer kiểm tra một dòng và dựng peer tương ứng (chưa lưu); others gồm cả các peer vừa tạo trong batch
+func (h *PeerHandler) provisionPeer(wn *services.WGNetwork, row provisionRow, groupIDs map[string]uint, others []models.Peer, reserved []addrRange) (models.Peer, error) {
+	if row.err != nil {
+		return models.Peer{}, row.err
+	}
+	name := strings.TrimSpace(row.Name)
+	if name == "" {
+		return models.Peer{}, fmt.Errorf("name is required")
+	}
+
+	var groupID *uint
+	if group := strings.TrimSpace(row.Group); group != "" {
+		id, ok := groupIDs[group]
+		if !ok {
+			return models.Peer{}, fmt.Errorf("group %q not found", group)
+		}
+		groupID = &id
+	}
+
+	var ip, ip6 string
+	var err error
+	if row.AllowedIPs != "" {
+		if ip, err = services.ValidatePeerAddress(row.AllowedIPs, wn.Address, others); err != nil {
+			return models.Peer{}, fmt.Errorf("invalid allowed_ips: %v", err)
+		}
+	} else if ip, err = allocateNextIP(wn.Address, others, reserved); err != nil {
+		return models.Peer{}, fmt.Errorf("IP allocation failed: %v", err)
+	}
+
+	if row.AllowedIPs6 != "" && wn.Address6 == "" {
+		return models.Peer{}, fmt.Errorf("IPv6 is not enabled on this network")
+	}
+	if row.AllowedIPs6 != "" {
+		if ip6, err = services.ValidatePeerAddress(row.AllowedIPs6, wn.Address6, others); err != nil {
+			return models.Peer{}, fmt.Errorf("invalid allowed_ips6: %v", err)
+		}
+	} else if wn.Address6 != "" {
+		if ip6, err = allocateNextIP(wn.Address6, others, reserved); err != nil {
+			return models.Peer{}, fmt.Errorf("IPv6 allocation failed: %v", err)
+		}
+	}
+
+	priv, pub, err := services.GenerateKeyPair()
+	if err != nil {
+		return models.Peer{}, fmt.Errorf("failed to generate keys")
+	}
+	var psk string
+	if h.cfg.PresharedKeys {
+		if psk, err = services.GeneratePresharedKey(); err != nil {
+			return models.Peer{}, fmt.Errorf("failed to generate preshared key")
+		}
+	}
+
+	return models.Peer{
+		NetworkID:     wn.ID,
+		Name:          name,
+		PublicKey:     pub,
+		PrivateKey:    priv,
+		PresharedKey:  psk,
+		AllowedIPs:    ip,
+		AllowedIPs6:   ip6,
+		GroupID:       groupID,
+		Tags:          normalizeTags(row.Tags),
+		UseAsExitNode: row.UseAsExitNode,
+		Enabled:       true,
+		Icon:          strings.TrimSpace(row.Icon),
+	}, nil
+}
+
+// readProvisionRows đọc danh sách peer từ JSON, CSV hoặc file upload (CSV/JSON)
+func readProvisionRows(c echo.Context) ([]provisionRow, error) {
+	contentType := c.Request().Header.Get(echo.HeaderContentType)
+	var data []byte
+	if strings.HasPrefix(contentType, echo.MIMEMultipartForm) {
+		file, err := c.FormFile("file")
+		if err != nil {
+			return nil, fmt.Errorf("Missing file")
+		}
+		f, err := file.Open()
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		if data, err = io.ReadAll(io.LimitReader(f, maxImportSize)); err != nil {
+			return nil, err
+		}
+		contentType = "text/csv"
+		if strings.HasSuffix(strings.ToLower(file.Filename), ".json") {
+			contentType = echo.MIMEApplicationJSON
+		}
+	} else {
+		var err error
+		if data, err = io.ReadAll(io.LimitReader(c.Request().Body, maxImportSize)); err != nil {
+			return nil, err
+		}
+	}
+
+	if strings.HasPrefix(contentType, "text/csv") {
+		return parseProvisionCSV(data)
+	}
+
+	var rows []provisionRow
+	if trimmed := strings.TrimSpace(string(data)); strings.HasPrefix(trimmed, "[") {
+		if err := json.Unmarshal(data, &rows); err != nil {
+			return nil, fmt.Errorf("Invalid JSON: %v", err)
+		}
+		return rows, nil
+	}
+	var req struct {
+		Peers []provisionRow `json:"peers"`
+	}
+	if err := json.Unmarshal(data, &req); err != nil {
+		return nil, fmt.Errorf("Invalid JSON: %v", err)
+	}
+	return req.Peers, nil
+}
+
+// parseProvisionCSV đọc CSV có header với các cột name, icon, use_as_exit_node, allowed_ips,
+// allowed_ips6, group, tags (phân cách bằng ";" hoặc ","). Thiếu cột nào thì dùng mặc định.
+func parseProvisionCSV(data []byte) ([]provisionRow, error) {
+	r := csv.NewReader(strings.NewReader(strings.TrimPrefix(string(data), "\ufeff")))
+	r.FieldsPerRecord = -1
+	r.TrimLeadingSpace = true
+
+	header, err := r.Read()
+	if err == io.EOF {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Invalid CSV: %v", err)
+	}
+	columns := make(map[string]int, len(header))
+	for i, col := range header {
+		columns[strings.ToLower(strings.TrimSpace(col))] = i
+	}
+	if _, ok := columns["name"]; !ok {
+		return nil, fmt.Errorf("CSV header must contain a \"name\" column")
+	}
+
+	var rows []provisionRow
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			if _, ok := err.(*csv.ParseError); !ok {
+				return nil, err
+			}
+			rows = append(rows, provisionRow{err: err})
+			continue
+		}
+		field := func(name string) string {
+			if i, ok := columns[name]; ok && i < len(record) {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+
+		row := provisionRow{
+			Name:        field("name"),
+			Icon:        field("icon"),
+			AllowedIPs:  field("allowed_ips"),
+			AllowedIPs6: field("allowed_ips6"),
+			Group:       field("group"),
+			Tags:        strings.FieldsFunc(field("tags"), func(r rune) bool { return r == ';' || r == ',' }),
+		}
+		if raw := field("use_as_exit_node"); raw != "" {
+			exitNode, err := strconv.ParseBool(raw)
+			if err != nil {
+				row.err = fmt.Errorf("invalid use_as_exit_node %q", raw)
+			}
+			row.UseAsExitNode = exitNode
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
@@ -0,0 +1,56 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+// GetServerInfo trả về thông tin công khai của mạng được chọn (public key hiện tại, endpoint, port)
+func (h *PeerHandler) GetServerInfo(c echo.Context) error {
+	wn, err := h.selectedNetwork(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	if wn.WG == nil {
+		return c.JSON(http.StatusServiceUnavailable, map[string]string{"error": "WireGuard controller is not available"})
+	}
+
+	pubKey, endpoint, port := wn.WG.GetServerConfig()
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"network_id": wn.ID,
+		"interface":  wn.InterfaceName,
+		"public_key": pubKey,
+		"endpoint":   endpoint,
+		"port":       port,
+		"address":    wn.Address,
+		"address6":   wn.Address6,
+	})
+}
+
+// RotateServerKey thay private key của mạng được chọn; mọi peer của mạng phải tải lại config
+func (h *PeerHandler) RotateServerKey(c echo.Context) error {
+	wn, err := h.selectedNetwork(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	if wn.WG == nil {
+		return c.JSON(http.StatusServiceUnavailable, map[string]string{"error": "WireGuard controller is not available"})
+	}
+
+	pubKey, err := wn.WG.RotateServerKey()
+	if err != nil {
+		if pubKey == "" {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		}
+		// Key đã được lưu, lần sync kế tiếp sẽ áp lên interface
+		log.Printf("Warning: %v", err)
+		h.peerSvc.SyncNetwork(wn.ID)
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{
+		"message":    "Server key rotated, every peer in this network must download its config again",
+		"public_key": pubKey,
+	})
+}
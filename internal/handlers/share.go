@@ -0,0 +1,179 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"wiretify/internal/database"
+	"wiretify/internal/models"
+	"wiretify/internal/services"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	defaultShareLinkTTL = 24 * time.Hour
+	maxShareLinkTTL     = 7 * 24 * time.Hour
+)
+
+// --- QR Code & Share Link Handlers ---
+
+// GetPeerConfigPNG trả về config của peer dưới dạng QR code PNG (?size=512)
+func (h *PeerHandler) GetPeerConfigPNG(c echo.Context) error {
+	var peer models.Peer
+	if err := database.DB.First(&peer, c.Param("id")).Error; err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Peer not found"})
+	}
+	return h.writePeerQR(c, peer, "png")
+}
+
+// GetPeerConfigSVG trả về config của peer dưới dạng QR code SVG
+func (h *PeerHandler) GetPeerConfigSVG(c echo.Context) error {
+	var peer models.Peer
+	if err := database.DB.First(&peer, c.Param("id")).Error; err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Peer not found"})
+	}
+	return h.writePeerQR(c, peer, "svg")
+}
+
+// CreateShareLink tạo link tải config dùng một lần, hết hạn sau ttl (mặc định 24h)
+func (h *PeerHandler) CreateShareLink(c echo.Context) error {
+	var peer models.Peer
+	if err := database.DB.First(&peer, c.Param("id")).Error; err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Peer not found"})
+	}
+	if peer.PrivateKey == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Peer manages its own private key, there is no config to share"})
+	}
+
+	var req struct {
+		TTL string `json:"ttl"` // vd. "1h", "24h"
+	}
+	if err := c.Bind(&req); err != nil {
+		return err
+	}
+
+	ttl := defaultShareLinkTTL
+	if req.TTL != "" {
+		d, err := time.ParseDuration(req.TTL)
+		if err != nil || d <= 0 || d > maxShareLinkTTL {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("ttl must be a duration between 1s and %s", maxShareLinkTTL)})
+		}
+		ttl = d
+	}
+
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to generate token"})
+	}
+
+	link := models.ShareLink{
+		Token:     base64.RawURLEncoding.EncodeToString(buf),
+		PeerID:    peer.ID,
+		ExpiresAt: time.Now().UTC().Add(ttl),
+	}
+	if err := database.DB.Create(&link).Error; err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+
+	// Link chỉ dùng được một lần nên chỉ trả về một URL; người nhận có thể thêm ?format=png|svg để lấy QR thay cho file
+	return c.JSON(http.StatusCreated, map[string]interface{}{
+		"url":        h.publicURL() + "/share/" + link.Token,
+		"expires_at": link.ExpiresAt,
+	})
+}
+
+// publicURL là địa chỉ web UI cho người ngoài: PUBLIC_URL nếu được cấu hình, ngược lại dựng từ SERVER_ENDPOINT.
+// Không dùng Host của request vì header này do client gửi lên.
+func (h *PeerHandler) publicURL() string {
+	if h.cfg.PublicURL != "" {
+		return strings.TrimRight(h.cfg.PublicURL, "/")
+	}
+	return "http://" + net.JoinHostPort(h.cfg.ServerEndpoint, "8080")
+}
+
+// OpenShareLink phục vụ link chia sẻ (không cần đăng nhập). Mỗi link chỉ dùng được một lần,
+// với ?format=conf (mặc định), png hoặc svg.
+func (h *PeerHandler) OpenShareLink(c echo.Context) error {
+	format := c.QueryParam("format")
+	if format == "" {
+		format = "conf"
+	}
+	if format != "conf" && format != "png" && format != "svg" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "format must be conf, png or svg"})
+	}
+
+	var link models.ShareLink
+	if err := database.DB.Where("token = ?", c.Param("token")).First(&link).Error; err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Link not found"})
+	}
+
+	// Kiểm tra peer trước khi dùng link để link vẫn còn nếu peer được bật lại
+	var peer models.Peer
+	if err := database.DB.First(&peer, link.PeerID).Error; err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Peer not found"})
+	}
+	if !peer.Enabled || (peer.ExpiresAt != nil && !peer.ExpiresAt.After(time.Now())) {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": "This machine is disabled or has expired"})
+	}
+
+	// Đánh dấu đã dùng một cách nguyên tử để hai request đồng thời không cùng lấy được config
+	now := time.Now().UTC()
+	res := database.DB.Model(&models.ShareLink{}).
+		Where("id = ? AND used_at IS NULL AND expires_at > ?", link.ID, now).
+		Update("used_at", now)
+	if res.Error != nil || res.RowsAffected != 1 {
+		return c.JSON(http.StatusGone, map[string]string{"error": "Link has expired or was already used"})
+	}
+
+	if format == "conf" {
+		confStr, err := h.buildPeerConfig(peer)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		}
+		markConfigDelivered(peer)
+		c.Response().Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s.conf", peer.Name))
+		c.Response().Header().Set("Content-Type", "application/x-wireguard-profile")
+		return c.String(http.StatusOK, confStr)
+	}
+	return h.writePeerQR(c, peer, format)
+}
+
+// isValidShareLink cho AuthMiddleware biết token có còn hiệu lực hay không
+func isValidShareLink(token string) bool {
+	var link models.ShareLink
+	err := database.DB.Where("token = ? AND used_at IS NULL AND expires_at > ?", token, time.Now().UTC()).First(&link).Error
+	return err == nil
+}
+
+func (h *PeerHandler) writePeerQR(c echo.Context, peer models.Peer, format string) error {
+	confStr, err := h.buildPeerConfig(peer)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	markConfigDelivered(peer)
+
+	c.Response().Header().Set("Cache-Control", "no-store")
+	if format == "svg" {
+		svg, err := services.QRCodeSVG(confStr)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		}
+		return c.Blob(http.StatusOK, "image/svg+xml", []byte(svg))
+	}
+
+	size, _ := strconv.Atoi(c.QueryParam("size"))
+	if size < 128 || size > 2048 {
+		size = 512
+	}
+	png, err := services.QRCodePNG(confStr, size)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	return c.Blob(http.StatusOK, "image/png", png)
+}
@@ -0,0 +1,101 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+	"wiretify/internal/database"
+	"wiretify/internal/models"
+
+	"github.com/labstack/echo/v4"
+)
+
+const maxStatsPoints = 2000
+
+// statsPoint là một điểm trong chuỗi thời gian trả về cho client
+type statsPoint struct {
+	Time          time.Time  `json:"time"`
+	RxBytes       int64      `json:"rx_bytes"`
+	TxBytes       int64      `json:"tx_bytes"`
+	LastHandshake *time.Time `json:"last_handshake"`
+}
+
+// GetPeerStats trả về lịch sử lưu lượng của peer, vd. ?range=24h&step=5m
+func (h *PeerHandler) GetPeerStats(c echo.Context) error {
+	var peer models.Peer
+	if err := database.DB.Unscoped().First(&peer, c.Param("id")).Error; err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Peer not found"})
+	}
+
+	rangeDur, err := parseStatsDuration(c.QueryParam("range"), 24*time.Hour)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid range: " + err.Error()})
+	}
+	step, err := parseStatsDuration(c.QueryParam("step"), 5*time.Minute)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid step: " + err.Error()})
+	}
+
+	// Ngoài khoảng giữ sample theo phút thì chỉ còn sample theo giờ
+	resolution := models.SampleResolutionMinute
+	if rangeDur > h.statsSvc.RawRetention() {
+		resolution = models.SampleResolutionHour
+	}
+	if minStep := time.Duration(resolution) * time.Second; step < minStep {
+		step = minStep
+	}
+	step = step.Truncate(time.Duration(resolution) * time.Second)
+	if rangeDur/step > maxStatsPoints {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("range/step yields more than %d points", maxStatsPoints)})
+	}
+
+	now := time.Now().UTC()
+	from := now.Add(-rangeDur).Truncate(step)
+
+	var samples []models.PeerSample
+	if err := database.DB.
+		Where("peer_id = ? AND bucket >= ?", peer.ID, from).
+		Order("bucket").
+		Find(&samples).Error; err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+
+	points := make([]statsPoint, 0, rangeDur/step+1)
+	for t := from; !t.After(now); t = t.Add(step) {
+		points = append(points, statsPoint{Time: t})
+	}
+	for _, s := range samples {
+		idx := int(s.Bucket.UTC().Sub(from) / step)
+		if idx < 0 || idx >= len(points) {
+			continue
+		}
+		p := &points[idx]
+		p.RxBytes += s.RxBytes
+		p.TxBytes += s.TxBytes
+		if s.LastHandshake != nil && (p.LastHandshake == nil || s.LastHandshake.After(*p.LastHandshake)) {
+			p.LastHandshake = s.LastHandshake
+		}
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"peer_id": peer.ID,
+		"from":    from,
+		"to":      now,
+		"step":    step.String(),
+		"points":  points,
+	})
+}
+
+func parseStatsDuration(value string, fallback time.Duration) (time.Duration, error) {
+	if value == "" {
+		return fallback, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, err
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("must be positive")
+	}
+	return d, nil
+}
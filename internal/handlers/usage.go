@@ -0,0 +1,63 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"wiretify/internal/models"
+
+	"github.com/labstack/echo/v4"
+)
+
+// --- Quota & Usage Handlers ---
+
+// SetPeerQuota cấu hình quota lưu lượng và chu kỳ reset của peer
+func (h *PeerHandler) SetPeerQuota(c echo.Context) error {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Peer not found"})
+	}
+
+	var req struct {
+		QuotaBytes int64  `json:"quota_bytes"` // 0: không giới hạn
+		Period     string `json:"period"`      // "", "monthly" hoặc "custom"
+		PeriodDays int    `json:"period_days"` // bắt buộc khi period = "custom"
+	}
+	if err := c.Bind(&req); err != nil {
+		return err
+	}
+
+	if req.QuotaBytes < 0 {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "quota_bytes cannot be negative"})
+	}
+	switch req.Period {
+	case "", models.QuotaPeriodMonthly:
+		req.PeriodDays = 0
+	case models.QuotaPeriodCustom:
+		if req.PeriodDays <= 0 {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "period_days must be positive for a custom period"})
+		}
+	default:
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "period must be empty, monthly or custom"})
+	}
+
+	// Nâng quota đủ lớn thì peer đã bị tắt do vượt quota được bật lại trong cùng transaction
+	peer, err := h.peerSvc.SetQuota(uint(id), req.QuotaBytes, req.Period, req.PeriodDays)
+	if err != nil {
+		return peerChangeError(c, err)
+	}
+	return c.JSON(http.StatusOK, peer)
+}
+
+// ResetPeerUsage đặt lại usage của chu kỳ hiện tại về 0 và bật lại peer nếu nó bị tắt do quota
+func (h *PeerHandler) ResetPeerUsage(c echo.Context) error {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Peer not found"})
+	}
+
+	peer, err := h.peerSvc.ResetUsage(uint(id))
+	if err != nil {
+		return peerChangeError(c, err)
+	}
+	return c.JSON(http.StatusOK, peer)
+}
@@ -0,0 +1,53 @@
+package models
+
+import "time"
+
+// ACLRule là một rule kiểm soát truy cập cho traffic đi vào từ interface WireGuard của mạng.
+// Các rule được so khớp theo Priority tăng dần (cùng priority thì theo ID), rule khớp đầu tiên quyết định;
+// không rule nào khớp thì áp dụng chế độ mặc định của mạng (Network.ACLMode).
+type ACLRule struct {
+	ID          uint   `gorm:"primaryKey" json:"id"`
+	NetworkID   uint   `gorm:"index;not null" json:"network_id"`
+	Priority    int    `json:"priority"`
+	Description string `json:"description"`
+
+	SourceType string `gorm:"not null" json:"source_type"` // any, peer, group hoặc cidr
+	Source     string `json:"source"`                      // ID của peer/group hoặc CIDR, rỗng khi any
+	DestType   string `gorm:"not null" json:"dest_type"`
+	Dest       string `json:"dest"`
+	Protocol   string `gorm:"not null" json:"protocol"` // all, tcp, udp hoặc icmp
+	Ports      string `json:"ports"`                    // Port/dải port đích, vd. "22,80,443" hoặc "8000-8100"; chỉ với tcp/udp
+	Action     string `gorm:"not null" json:"action"`   // accept hoặc drop
+	Enabled    bool   `json:"enabled"`
+
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Loại source/destination của ACLRule
+const (
+	ACLTargetAny   = "any"
+	ACLTargetPeer  = "peer"
+	ACLTargetGroup = "group"
+	ACLTargetCIDR  = "cidr"
+)
+
+// Protocol của ACLRule
+const (
+	ACLProtocolAll  = "all"
+	ACLProtocolTCP  = "tcp"
+	ACLProtocolUDP  = "udp"
+	ACLProtocolICMP = "icmp"
+)
+
+// Action của ACLRule
+const (
+	ACLActionAccept = "accept"
+	ACLActionDrop   = "drop"
+)
+
+// Chế độ mặc định khi không rule nào khớp
+const (
+	ACLModeAllow = "allow" // Mặc định (kể cả khi rỗng): cho phép, rule dùng để chặn
+	ACLModeDeny  = "deny"  // Chặn tất cả trừ những gì rule cho phép
+)
@@ -8,6 +8,7 @@ import (
 
 type Endpoint struct {
 	ID        uint           `gorm:"primaryKey" json:"id"`
+	NetworkID uint           `gorm:"index" json:"network_id"`
 	PeerID    uint           `gorm:"not null" json:"peer_id"`
 	Peer      Peer           `json:"peer"`
 	DomainID  uint           `gorm:"not null" json:"domain_id"`
@@ -0,0 +1,12 @@
+package models
+
+import "time"
+
+// Group gom các peer để lọc, thao tác hàng loạt và dùng làm selector (vd. chủ sở hữu port forward)
+type Group struct {
+	ID          uint      `gorm:"primaryKey" json:"id"`
+	Name        string    `gorm:"uniqueIndex;not null" json:"name"`
+	Description string    `json:"description"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
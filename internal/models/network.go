@@ -0,0 +1,19 @@
+package models
+
+import "time"
+
+// Network là một mạng WireGuard độc lập (interface, subnet, port và server key riêng),
+// vd. "staff" và "iot" chạy trên cùng một server
+type Network struct {
+	ID            uint      `gorm:"primaryKey" json:"id"`
+	Name          string    `gorm:"uniqueIndex;not null" json:"name"`
+	InterfaceName string    `gorm:"uniqueIndex;not null" json:"interface_name"`
+	Address       string    `gorm:"not null" json:"address"` // IP server kèm subnet, vd. 10.8.0.1/24
+	Address6      string    `json:"address6,omitempty"`      // Optional IPv6, vd. fd86:ea04:1115::1/64
+	ListenPort    int       `gorm:"uniqueIndex;not null" json:"listen_port"`
+	Endpoint      string    `json:"endpoint"`                   // Host public cho client, rỗng: dùng SERVER_ENDPOINT
+	PrivateKey    string    `gorm:"serializer:secret" json:"-"` // Server key của mạng, được mã hoá trong DB
+	ACLMode       string    `json:"-"`                          // allow (mặc định) hoặc deny, đọc/ghi qua /api/acl
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
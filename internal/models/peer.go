@@ -1,32 +1,133 @@
 package models
 
 import (
+	"net"
 	"time"
 
 	"gorm.io/gorm"
 )
 
 type Peer struct {
-	ID          uint           `gorm:"primaryKey" json:"id"`
-	Name        string         `gorm:"uniqueIndex;not null" json:"name"`
-	PublicKey   string         `gorm:"uniqueIndex;not null" json:"public_key"`
-	PrivateKey  string         `json:"private_key,omitempty"` // Chỉ lưu nếu app tự gen
-	AllowedIPs    string         `json:"allowed_ips"`
-	Endpoints     string         `json:"endpoint"`
-	UseAsExitNode bool           `gorm:"default:true" json:"use_as_exit_node"`
-	Enabled       bool           `gorm:"default:true" json:"enabled"`
-	Icon          string         `json:"icon"`
-	CreatedAt   time.Time      `json:"created_at"`
-	UpdatedAt   time.Time      `json:"updated_at"`
-	DeletedAt   gorm.DeletedAt `gorm:"index" json:"-"`
+	ID             uint       `gorm:"primaryKey" json:"id"`
+	NetworkID      uint       `gorm:"index;uniqueIndex:idx_peers_network_name" json:"network_id"`
+	Name           string     `gorm:"uniqueIndex:idx_peers_network_name;not null" json:"name"` // Unique trong mỗi mạng
+	PublicKey      string     `gorm:"uniqueIndex;not null" json:"public_key"`
+	PrivateKey     string     `gorm:"serializer:secret" json:"-"` // Chỉ lưu nếu app tự gen, được mã hoá trong DB; chỉ ra ngoài qua config/QR
+	PresharedKey   string     `gorm:"serializer:secret" json:"-"` // PSK, được mã hoá trong DB
+	AllowedIPs     string     `json:"allowed_ips"`
+	AllowedIPs6    string     `gorm:"column:allowed_ips6" json:"allowed_ips6,omitempty"` // IPv6 /128, chỉ có khi bật WG_ADDRESS6
+	RoutedSubnets  []string   `gorm:"serializer:json" json:"routed_subnets"`             // LAN phía sau peer (site-to-site)
+	Endpoints      string     `json:"endpoint"`
+	GroupID        *uint      `gorm:"index" json:"group_id"`       // nil: không thuộc group nào
+	Tags           []string   `gorm:"serializer:json" json:"tags"` // Tag tự do, vd. "laptop", "hanoi"
+	UseAsExitNode  bool       `gorm:"default:true" json:"use_as_exit_node"`
+	Enabled        bool       `gorm:"default:true" json:"enabled"`
+	DisabledReason string     `json:"disabled_reason,omitempty"` // Lý do bị tắt tự động, vd. "expired"
+	ExpiresAt      *time.Time `gorm:"index" json:"expires_at"`   // nil: không hết hạn
+
+	// Mesh mode: peer ở vị trí cố định kết nối trực tiếp với các mesh member khác, hub là đường dự phòng
+	Mesh         bool   `json:"mesh"`
+	MeshEndpoint string `json:"mesh_endpoint"` // host:port cố định; rỗng thì dùng IP mà hub thấy kèm MeshPort
+	MeshPort     int    `json:"mesh_port"`     // ListenPort trong config của peer để endpoint không đổi
+
+	// Overlap window khi rotate key: key cũ vẫn được giữ trên device tới khi key mới handshake hoặc hết hạn
+	PreviousPublicKey    string     `gorm:"index" json:"previous_public_key,omitempty"`
+	PreviousKeyExpiresAt *time.Time `json:"previous_key_expires_at,omitempty"`
+	ConfigOutdated       bool       `json:"config_outdated"` // Key của server/peer đã đổi từ lần tải config trước, cần tải lại
+
+	// Quota & usage (cộng dồn theo chu kỳ, không bị mất khi interface bị tạo lại)
+	QuotaBytes       int64          `json:"quota_bytes"`       // 0: không giới hạn
+	QuotaPeriod      string         `json:"quota_period"`      // "", "monthly" hoặc "custom"
+	QuotaPeriodDays  int            `json:"quota_period_days"` // Độ dài chu kỳ khi QuotaPeriod = "custom"
+	UsagePeriodStart *time.Time     `json:"usage_period_start"`
+	UsageRxBytes     int64          `json:"usage_rx_bytes"`
+	UsageTxBytes     int64          `json:"usage_tx_bytes"`
+	LastKernelRx     int64          `json:"-"` // Bộ đếm kernel ở lần đọc trước, để tính delta
+	LastKernelTx     int64          `json:"-"`
+	Icon             string         `json:"icon"`
+	ProfileID        *uint          `json:"profile_id"` // nil: dùng UseAsExitNode để chọn full/split tunnel
+	CreatedAt        time.Time      `json:"created_at"`
+	UpdatedAt        time.Time      `json:"updated_at"`
+	DeletedAt        gorm.DeletedAt `gorm:"index" json:"-"`
 
 	// Runtime stats (không lưu DB)
-	Connected       bool      `gorm:"-" json:"connected"`
-	RxBytes         int64     `gorm:"-" json:"rx_bytes"`
-	TxBytes         int64     `gorm:"-" json:"tx_bytes"`
-	LastHandshake   time.Time `gorm:"-" json:"last_handshake"`
+	Connected     bool      `gorm:"-" json:"connected"`
+	RxBytes       int64     `gorm:"-" json:"rx_bytes"`
+	TxBytes       int64     `gorm:"-" json:"tx_bytes"`
+	LastHandshake time.Time `gorm:"-" json:"last_handshake"`
+
+	UsageBytes     int64  `gorm:"-" json:"usage_bytes"`
+	QuotaRemaining *int64 `gorm:"-" json:"quota_remaining"` // nil khi không giới hạn
+	HasPrivateKey  bool   `gorm:"-" json:"has_private_key"` // Server giữ private key nên có thể xuất config đầy đủ/QR
+}
+
+// Lý do peer bị tắt tự động
+const (
+	DisabledReasonExpired = "expired"
+	DisabledReasonQuota   = "quota"
+)
+
+// Chu kỳ reset usage
+const (
+	QuotaPeriodMonthly = "monthly"
+	QuotaPeriodCustom  = "custom"
+)
+
+// AfterFind tính các field usage/quota cho API
+func (p *Peer) AfterFind(tx *gorm.DB) error {
+	if p.Tags == nil {
+		p.Tags = []string{}
+	}
+	p.HasPrivateKey = p.PrivateKey != ""
+	p.UsageBytes = p.UsageRxBytes + p.UsageTxBytes
+	p.QuotaRemaining = nil
+	if p.QuotaBytes > 0 {
+		remaining := p.QuotaBytes - p.UsageBytes
+		if remaining < 0 {
+			remaining = 0
+		}
+		p.QuotaRemaining = &remaining
+	}
+	return nil
+}
+
+// AfterSave cập nhật HasPrivateKey cho response sau khi tạo/sửa peer
+func (p *Peer) AfterSave(tx *gorm.DB) error {
+	p.HasPrivateKey = p.PrivateKey != ""
+	return nil
+}
+
+// UsagePeriodEnd trả về thời điểm chu kỳ usage hiện tại kết thúc (zero nếu không có chu kỳ)
+func (p Peer) UsagePeriodEnd() time.Time {
+	if p.UsagePeriodStart == nil {
+		return time.Time{}
+	}
+	switch p.QuotaPeriod {
+	case QuotaPeriodMonthly:
+		return p.UsagePeriodStart.AddDate(0, 1, 0)
+	case QuotaPeriodCustom:
+		if p.QuotaPeriodDays > 0 {
+			return p.UsagePeriodStart.AddDate(0, 0, p.QuotaPeriodDays)
+		}
+	}
+	return time.Time{}
 }
 
+// IP trả về địa chỉ IPv4 nội bộ của peer, ví dụ "10.8.0.3" từ "10.8.0.3/32".
+// Port forward tham chiếu peer qua địa chỉ này (TargetNode).
+func (p Peer) IP() string {
+	if ip, _, err := net.ParseCIDR(p.AllowedIPs); err == nil {
+		return ip.String()
+	}
+	return p.AllowedIPs
+}
+
+// Key của các setting đặc biệt
+const (
+	SettingServerPrivateKey = "server_private_key" // Server key từ trước khi có nhiều mạng, được chuyển vào Network khi khởi động
+	SettingExitNodeMigrated = "exit_node_migrated" // Đã chuẩn hoá use_as_exit_node của các peer cũ
+)
+
 type Setting struct {
 	Key   string `gorm:"primaryKey"`
 	Value string
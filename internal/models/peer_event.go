@@ -0,0 +1,26 @@
+package models
+
+import "time"
+
+// Các loại sự kiện của peer
+const (
+	PeerEventExpired       = "expired"
+	PeerEventQuotaExceeded = "quota_exceeded"
+	PeerEventQuotaReset    = "quota_reset"
+	PeerEventConnected     = "connected"
+	PeerEventDisconnected  = "disconnected"
+	PeerEventRoamed        = "roamed"
+	PeerEventKeyRotated    = "key_rotated"
+	PeerEventImported      = "imported"
+)
+
+// PeerEvent ghi lại các sự kiện đáng chú ý của một peer (hết hạn, vượt quota, kết nối/roaming...)
+type PeerEvent struct {
+	ID         uint      `gorm:"primaryKey" json:"id"`
+	PeerID     uint      `gorm:"not null;index" json:"peer_id"`
+	Type       string    `gorm:"not null;index" json:"type"`
+	Message    string    `json:"message"`
+	SourceIP   string    `gorm:"index" json:"source_ip,omitempty"` // Endpoint công khai của peer với sự kiện kết nối
+	SourcePort int       `json:"source_port,omitempty"`
+	CreatedAt  time.Time `gorm:"index" json:"created_at"`
+}
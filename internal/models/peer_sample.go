@@ -0,0 +1,21 @@
+package models
+
+import "time"
+
+// Độ phân giải của PeerSample (giây)
+const (
+	SampleResolutionMinute = 60
+	SampleResolutionHour   = 3600
+)
+
+// PeerSample là lưu lượng rx/tx (delta) và handshake gần nhất của một peer trong một bucket thời gian.
+// Sample theo phút được gộp dần thành sample theo giờ để bảng luôn nhỏ gọn.
+type PeerSample struct {
+	ID            uint       `gorm:"primaryKey" json:"-"`
+	PeerID        uint       `gorm:"not null;uniqueIndex:idx_peer_sample_bucket" json:"peer_id"`
+	Resolution    int        `gorm:"not null;uniqueIndex:idx_peer_sample_bucket" json:"resolution"`
+	Bucket        time.Time  `gorm:"not null;uniqueIndex:idx_peer_sample_bucket" json:"bucket"`
+	RxBytes       int64      `json:"rx_bytes"`
+	TxBytes       int64      `json:"tx_bytes"`
+	LastHandshake *time.Time `json:"last_handshake"`
+}
@@ -8,6 +8,8 @@ import (
 
 type PortForward struct {
 	ID         uint           `gorm:"primaryKey" json:"id"`
+	NetworkID  uint           `gorm:"index" json:"network_id"`
+	GroupID    *uint          `gorm:"index" json:"group_id"` // Group sở hữu forward, nil: không thuộc group nào
 	PublicPort int            `gorm:"not null" json:"public_port"`
 	TargetNode string         `gorm:"not null" json:"target_node"`
 	TargetPort int            `gorm:"not null" json:"target_port"`
@@ -0,0 +1,28 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Các chế độ định tuyến của client config
+const (
+	ProfileModeFull   = "full"   // Toàn bộ traffic đi qua VPN
+	ProfileModeSplit  = "split"  // Chỉ subnet VPN (và LAN của các site)
+	ProfileModeCustom = "custom" // Danh sách CIDR tự định nghĩa trong Routes
+)
+
+// Profile là mẫu cấu hình client (routing, DNS, MTU, keepalive) được gán cho peer
+type Profile struct {
+	ID                  uint           `gorm:"primaryKey" json:"id"`
+	Name                string         `gorm:"uniqueIndex;not null" json:"name"`
+	Mode                string         `gorm:"not null;default:'full'" json:"mode"`
+	Routes              []string       `gorm:"serializer:json" json:"routes"`
+	DNS                 []string       `gorm:"serializer:json" json:"dns"`
+	MTU                 int            `json:"mtu"`
+	PersistentKeepalive int            `json:"persistent_keepalive"`
+	CreatedAt           time.Time      `json:"created_at"`
+	UpdatedAt           time.Time      `json:"updated_at"`
+	DeletedAt           gorm.DeletedAt `gorm:"index" json:"-"`
+}
@@ -0,0 +1,15 @@
+package models
+
+import "time"
+
+// Reservation giữ một dải địa chỉ của mạng ngoài việc cấp phát tự động.
+// Vẫn có thể gán IP trong dải này cho peer bằng cách chỉ định địa chỉ khi tạo/sửa peer.
+type Reservation struct {
+	ID          uint      `gorm:"primaryKey" json:"id"`
+	NetworkID   uint      `gorm:"index;not null" json:"network_id"`
+	StartIP     string    `gorm:"not null" json:"start_ip"`
+	EndIP       string    `gorm:"not null" json:"end_ip"` // Bằng StartIP nếu chỉ giữ một địa chỉ
+	Description string    `json:"description"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
@@ -0,0 +1,13 @@
+package models
+
+import "time"
+
+// ShareLink là link dùng một lần, cho phép người chưa đăng nhập tải config/QR của một peer
+type ShareLink struct {
+	ID        uint       `gorm:"primaryKey" json:"id"`
+	Token     string     `gorm:"uniqueIndex;not null" json:"-"`
+	PeerID    uint       `gorm:"not null;index" json:"peer_id"`
+	ExpiresAt time.Time  `gorm:"not null" json:"expires_at"`
+	UsedAt    *time.Time `json:"used_at"`
+	CreatedAt time.Time  `json:"created_at"`
+}
@@ -0,0 +1,222 @@
+package secrets
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+// Định dạng giá trị đã mã hoá (envelope encryption):
+//
+//	enc:v1:<base64(data key đã được master key bọc)>:<base64(nonce|ciphertext)>
+//
+// Mỗi giá trị có một data key ngẫu nhiên riêng; master key chỉ dùng để bọc data key.
+const sealedPrefix = "enc:v1:"
+
+const keySize = 32
+
+var masterKey []byte
+
+// Init nạp master key từ biến môi trường (base64) hoặc từ key file.
+// Nếu cả hai đều trống, một key mới được sinh và ghi vào keyFile với mode 0600.
+func Init(encodedKey, keyFile string) error {
+	if encodedKey != "" {
+		key, err := DecodeKey(encodedKey)
+		if err != nil {
+			return fmt.Errorf("invalid master key: %v", err)
+		}
+		masterKey = key
+		return nil
+	}
+
+	data, err := os.ReadFile(keyFile)
+	if err == nil {
+		key, err := DecodeKey(strings.TrimSpace(string(data)))
+		if err != nil {
+			return fmt.Errorf("invalid master key file %s: %v", keyFile, err)
+		}
+		masterKey = key
+		return nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	key, err := GenerateKey()
+	if err != nil {
+		return err
+	}
+	if err := WriteKeyFile(keyFile, key); err != nil {
+		return err
+	}
+	log.Printf("Generated new master key in %s", keyFile)
+	masterKey = key
+	return nil
+}
+
+// GenerateKey sinh một master key ngẫu nhiên mới
+func GenerateKey() ([]byte, error) {
+	key := make([]byte, keySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// EncodeKey trả về dạng base64 của key, dùng cho MASTER_KEY hoặc key file
+func EncodeKey(key []byte) string {
+	return base64.StdEncoding.EncodeToString(key)
+}
+
+// WriteKeyFile ghi key ra file với mode 0600
+func WriteKeyFile(path string, key []byte) error {
+	if err := os.WriteFile(path, []byte(EncodeKey(key)+"\n"), 0600); err != nil {
+		return fmt.Errorf("failed to write master key file %s: %v", path, err)
+	}
+	return nil
+}
+
+// DecodeKey giải mã master key dạng base64 và kiểm tra độ dài
+func DecodeKey(encoded string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, err
+	}
+	if len(key) != keySize {
+		return nil, fmt.Errorf("master key must be %d bytes, got %d", keySize, len(key))
+	}
+	return key, nil
+}
+
+// IsSealed reports whether value is in the encrypted storage format.
+func IsSealed(value string) bool {
+	return strings.HasPrefix(value, sealedPrefix)
+}
+
+// Seal mã hoá plaintext bằng một data key mới, data key được bọc bởi master key.
+// Chuỗi rỗng được giữ nguyên để phân biệt "không có giá trị".
+func Seal(plaintext string) (string, error) {
+	if plaintext == "" {
+		return "", nil
+	}
+	if masterKey == nil {
+		return "", errors.New("secrets: master key not initialized")
+	}
+
+	dataKey := make([]byte, keySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return "", err
+	}
+
+	wrappedKey, err := encrypt(masterKey, dataKey)
+	if err != nil {
+		return "", err
+	}
+	ciphertext, err := encrypt(dataKey, []byte(plaintext))
+	if err != nil {
+		return "", err
+	}
+
+	return sealedPrefix + base64.StdEncoding.EncodeToString(wrappedKey) + ":" + base64.StdEncoding.EncodeToString(ciphertext), nil
+}
+
+// Open giải mã giá trị tạo bởi Seal. Giá trị chưa mã hoá (dữ liệu cũ) được trả về nguyên vẹn.
+func Open(value string) (string, error) {
+	if !IsSealed(value) {
+		return value, nil
+	}
+	if masterKey == nil {
+		return "", errors.New("secrets: master key not initialized")
+	}
+
+	parts := strings.SplitN(strings.TrimPrefix(value, sealedPrefix), ":", 2)
+	if len(parts) != 2 {
+		return "", errors.New("secrets: malformed sealed value")
+	}
+	wrappedKey, err := base64.StdEncoding.DecodeString(parts[0])
+	if err != nil {
+		return "", err
+	}
+	ciphertext, err := base64.StdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", err
+	}
+
+	dataKey, err := decrypt(masterKey, wrappedKey)
+	if err != nil {
+		return "", fmt.Errorf("secrets: failed to unwrap data key: %v", err)
+	}
+	plaintext, err := decrypt(dataKey, ciphertext)
+	if err != nil {
+		return "", err
+	}
+	return string(plaintext), nil
+}
+
+// Rewrap bọc lại data key của một giá trị đã mã hoá bằng newKey, ciphertext giữ nguyên.
+// Dùng khi đổi master key: không cần giải mã lại dữ liệu.
+func Rewrap(value string, newKey []byte) (string, error) {
+	if !IsSealed(value) {
+		return value, nil
+	}
+	if masterKey == nil {
+		return "", errors.New("secrets: master key not initialized")
+	}
+
+	parts := strings.SplitN(strings.TrimPrefix(value, sealedPrefix), ":", 2)
+	if len(parts) != 2 {
+		return "", errors.New("secrets: malformed sealed value")
+	}
+	wrappedKey, err := base64.StdEncoding.DecodeString(parts[0])
+	if err != nil {
+		return "", err
+	}
+	dataKey, err := decrypt(masterKey, wrappedKey)
+	if err != nil {
+		return "", fmt.Errorf("secrets: failed to unwrap data key: %v", err)
+	}
+	rewrapped, err := encrypt(newKey, dataKey)
+	if err != nil {
+		return "", err
+	}
+	return sealedPrefix + base64.StdEncoding.EncodeToString(rewrapped) + ":" + parts[1], nil
+}
+
+// encrypt dùng AES-256-GCM, nonce được đặt ở đầu ciphertext
+func encrypt(key, plaintext []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+func decrypt(key, data []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < gcm.NonceSize() {
+		return nil, errors.New("ciphertext too short")
+	}
+	nonce, ciphertext := data[:gcm.NonceSize()], data[gcm.NonceSize():]
+	return gcm.Open(nil, nonce, ciphertext, nil)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,200 @@
+package services
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"wiretify/internal/database"
+	"wiretify/internal/models"
+
+	"github.com/coreos/go-iptables/iptables"
+	"gorm.io/gorm"
+)
+
+// ACLChain là chain chứa rule ACL của một interface, được gọi từ FORWARD cho traffic vào từ interface đó.
+// "WIRETIFY-ACL-" cộng tên interface (tối đa 15 ký tự) vừa giới hạn 28 ký tự của iptables.
+func ACLChain(iface string) string {
+	return "WIRETIFY-ACL-" + iface
+}
+
+// aclHookRule đưa traffic vào từ interface qua chain ACL; được chèn lên đầu FORWARD để chạy trước rule ACCEPT của interface
+func aclHookRule(iface string) firewallRule {
+	return firewallRule{"filter", "FORWARD", []string{"-i", iface, "-j", ACLChain(iface)}}
+}
+
+// ACLRuleSet là nội dung chain ACL của một mạng theo từng họ địa chỉ, đúng thứ tự trong chain
+type ACLRuleSet map[iptables.Protocol][]firewallRule
+
+// Commands trả về các lệnh iptables/ip6tables dựng chain, để hiển thị trên UI
+func (set ACLRuleSet) Commands() []string {
+	var cmds []string
+	for _, f := range []struct {
+		proto iptables.Protocol
+		cmd   string
+	}{{iptables.ProtocolIPv4, "iptables"}, {iptables.ProtocolIPv6, "ip6tables"}} {
+		for _, r := range set[f.proto] {
+			cmds = append(cmds, r.command(f.cmd, "-A"))
+		}
+	}
+	return cmds
+}
+
+// CompileACL đọc chế độ, rule và peer của mạng từ db (DB hoặc transaction đang chạy) và biên dịch thành chain ACL.
+// Peer được thay bằng địa chỉ VPN và các subnet LAN phía sau nó, group bằng địa chỉ của mọi peer trong group.
+// Rule không có địa chỉ nào thuộc một họ (vd. CIDR IPv6, group rỗng) bị bỏ qua ở họ đó.
+func CompileACL(db *gorm.DB, networkID uint) (ACLRuleSet, error) {
+	var network models.Network
+	if err := db.First(&network, networkID).Error; err != nil {
+		return nil, err
+	}
+	var rules []models.ACLRule
+	if err := db.Where("network_id = ? AND enabled = ?", networkID, true).Order("priority, id").Find(&rules).Error; err != nil {
+		return nil, err
+	}
+	var peers []models.Peer
+	if err := db.Where("network_id = ?", networkID).Find(&peers).Error; err != nil {
+		return nil, err
+	}
+
+	families := []iptables.Protocol{iptables.ProtocolIPv4}
+	if network.Address6 != "" {
+		families = append(families, iptables.ProtocolIPv6)
+	}
+
+	chain := ACLChain(network.InterfaceName)
+	set := make(ACLRuleSet)
+	for _, proto := range families {
+		// Chiều trả về của kết nối đã được cho phép (vd. peer B trả lời peer A) cũng đi vào từ interface
+		compiled := []firewallRule{{"filter", chain, []string{"-m", "state", "--state", "RELATED,ESTABLISHED", "-j", "ACCEPT"}}}
+		for _, r := range rules {
+			src, ok := aclPrefixes(r.SourceType, r.Source, peers, proto)
+			if !ok {
+				continue
+			}
+			dst, ok := aclPrefixes(r.DestType, r.Dest, peers, proto)
+			if !ok {
+				continue
+			}
+			compiled = append(compiled, firewallRule{"filter", chain, aclRuleSpec(r, src, dst, proto)})
+		}
+
+		// allow: quay lại FORWARD để rule ACCEPT của interface xử lý tiếp
+		target := "RETURN"
+		if network.ACLMode == models.ACLModeDeny {
+			target = "DROP"
+		}
+		set[proto] = append(compiled, firewallRule{"filter", chain, []string{"-j", target}})
+	}
+	return set, nil
+}
+
+// aclPrefixes trả về các prefix thuộc họ proto mà source/destination trỏ tới; rỗng nghĩa là mọi địa chỉ.
+// ok là false khi target không có địa chỉ nào thuộc họ này và rule phải bị bỏ qua.
+func aclPrefixes(kind, value string, peers []models.Peer, proto iptables.Protocol) ([]string, bool) {
+	var candidates []string
+	switch kind {
+	case models.ACLTargetAny:
+		return nil, true
+	case models.ACLTargetCIDR:
+		candidates = []string{value}
+	case models.ACLTargetPeer, models.ACLTargetGroup:
+		id, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			return nil, false
+		}
+		for _, p := range peers {
+			if (kind == models.ACLTargetPeer && p.ID == uint(id)) || (kind == models.ACLTargetGroup && p.GroupID != nil && *p.GroupID == uint(id)) {
+				candidates = append(candidates, MeshAllowedIPs(p)...)
+			}
+		}
+	}
+
+	var prefixes []string
+	for _, c := range candidates {
+		ip, _, err := net.ParseCIDR(c)
+		if err != nil {
+			continue
+		}
+		if (ip.To4() != nil) == (proto == iptables.ProtocolIPv4) {
+			prefixes = append(prefixes, c)
+		}
+	}
+	return prefixes, len(prefixes) > 0
+}
+
+// aclRuleSpec dựng iptables spec cho một rule; nhiều địa chỉ được nối bằng dấu phẩy để iptables tự tách thành nhiều rule
+func aclRuleSpec(r models.ACLRule, src, dst []string, proto iptables.Protocol) []string {
+	var spec []string
+	if len(src) > 0 {
+		spec = append(spec, "-s", strings.Join(src, ","))
+	}
+	if len(dst) > 0 {
+		spec = append(spec, "-d", strings.Join(dst, ","))
+	}
+	switch r.Protocol {
+	case models.ACLProtocolTCP, models.ACLProtocolUDP:
+		spec = append(spec, "-p", r.Protocol)
+		if r.Ports != "" {
+			spec = append(spec, "-m", "multiport", "--dports", strings.ReplaceAll(r.Ports, "-", ":"))
+		}
+	case models.ACLProtocolICMP:
+		if proto == iptables.ProtocolIPv6 {
+			spec = append(spec, "-p", "icmpv6")
+		} else {
+			spec = append(spec, "-p", "icmp")
+		}
+	}
+
+	target := "DROP"
+	if r.Action == models.ACLActionAccept {
+		target = "ACCEPT"
+	}
+	return append(spec, "-m", "comment", "--comment", fmt.Sprintf("wiretify-acl-%d", r.ID), "-j", target)
+}
+
+// applyACL biên dịch ACL của mạng từ db và nạp vào kernel
+func applyACL(db *gorm.DB, wn *WGNetwork) error {
+	set, err := CompileACL(db, wn.ID)
+	if err != nil {
+		return err
+	}
+	return wn.Net.ApplyACL(set)
+}
+
+// syncACL nạp lại chain ACL theo DB sau khi peer thay đổi. Lỗi chỉ được log để thay đổi peer
+// không bị rollback vì iptables; chain được nạp lại ở lần thay đổi hoặc sync tiếp theo.
+func (s *PeerService) syncACL(wn *WGNetwork) {
+	if err := applyACL(database.DB, wn); err != nil {
+		log.Printf("Warning: failed to apply access control rules on %s: %v", wn.InterfaceName, err)
+	}
+}
+
+// ChangeACL chạy fn (sửa rule hoặc chế độ ACL) trong transaction, với các thay đổi peer khác bị chặn,
+// và nạp chain mới vào kernel trước khi commit. Trả về *ApplyError nếu iptables lỗi (DB đã được rollback).
+func (s *PeerService) ChangeACL(networkID uint, fn func(tx *gorm.DB) error) error {
+	wn := s.networks.Get(networkID)
+	if wn == nil {
+		return fmt.Errorf("network %d not found", networkID)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	applied := false
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := fn(tx); err != nil {
+			return err
+		}
+		applied = true
+		if err := applyACL(tx, wn); err != nil {
+			return &ApplyError{Interface: wn.InterfaceName, Err: err}
+		}
+		return nil
+	})
+	if err != nil && applied {
+		s.syncACL(wn)
+	}
+	return err
+}
@@ -0,0 +1,55 @@
+package services
+
+import (
+	"fmt"
+	"net/netip"
+	"strings"
+	"wiretify/internal/models"
+)
+
+// ValidatePeerAddress kiểm tra địa chỉ host (vd. "10.8.0.5" hoặc "10.8.0.5/32") thuộc subnet của server,
+// không trùng server IP, network/broadcast hay IP của peer khác. Trả về dạng CIDR chuẩn hoá.
+func ValidatePeerAddress(addr, baseCIDR string, others []models.Peer) (string, error) {
+	prefix, err := netip.ParsePrefix(baseCIDR)
+	if err != nil {
+		return "", err
+	}
+	network := prefix.Masked()
+
+	addr = strings.TrimSpace(addr)
+	var ip netip.Addr
+	if strings.Contains(addr, "/") {
+		p, err := netip.ParsePrefix(addr)
+		if err != nil {
+			return "", err
+		}
+		if !p.IsSingleIP() {
+			return "", fmt.Errorf("%s is not a single host address", addr)
+		}
+		ip = p.Addr()
+	} else {
+		ip, err = netip.ParseAddr(addr)
+		if err != nil {
+			return "", err
+		}
+	}
+	ip = ip.Unmap()
+
+	if !network.Contains(ip) {
+		return "", fmt.Errorf("%s is outside of subnet %s", ip, network)
+	}
+	if ip == prefix.Addr().Unmap() {
+		return "", fmt.Errorf("%s is the server address", ip)
+	}
+	if ip == network.Addr() || ip.Is4() && !network.Contains(ip.Next()) {
+		return "", fmt.Errorf("%s is a reserved network address", ip)
+	}
+
+	result := netip.PrefixFrom(ip, ip.BitLen()).String()
+	for _, o := range others {
+		if o.AllowedIPs == result || o.AllowedIPs6 == result {
+			return "", fmt.Errorf("%s is already assigned to %s", ip, o.Name)
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,68 @@
+package services
+
+import (
+	"strings"
+	"text/template"
+)
+
+// ClientConfig chứa các giá trị để render file wg-quick cho một client
+type ClientConfig struct {
+	PrivateKey string
+	Address    []string
+	DNS        []string
+	MTU        int
+	ListenPort int          // Chỉ đặt cho mesh member để các peer khác kết nối trực tiếp được
+	Peers      []ClientPeer // Hub luôn đứng đầu, tiếp theo là các mesh member kết nối trực tiếp
+}
+
+// ClientPeer là một [Peer] trong config của client
+type ClientPeer struct {
+	Name                string // Ghi thành comment phía trên [Peer]
+	PublicKey           string
+	PresharedKey        string
+	Endpoint            string
+	AllowedIPs          []string
+	PersistentKeepalive int
+}
+
+var clientConfigTpl = template.Must(template.New("client").Funcs(template.FuncMap{
+	"join": strings.Join,
+}).Parse(`[Interface]
+PrivateKey = {{.PrivateKey}}
+Address = {{join .Address ", "}}
+{{- if .ListenPort}}
+ListenPort = {{.ListenPort}}
+{{- end}}
+{{- if .DNS}}
+DNS = {{join .DNS ", "}}
+{{- end}}
+{{- if .MTU}}
+MTU = {{.MTU}}
+{{- end}}
+{{- range .Peers}}
+
+{{if .Name}}# {{.Name}}
+{{end -}}
+[Peer]
+PublicKey = {{.PublicKey}}
+{{- if .PresharedKey}}
+PresharedKey = {{.PresharedKey}}
+{{- end}}
+{{- if .Endpoint}}
+Endpoint = {{.Endpoint}}
+{{- end}}
+AllowedIPs = {{join .AllowedIPs ", "}}
+{{- if .PersistentKeepalive}}
+PersistentKeepalive = {{.PersistentKeepalive}}
+{{- end}}
+{{- end}}
+`))
+
+// RenderClientConfig render cấu hình wg-quick cho client
+func RenderClientConfig(cc ClientConfig) (string, error) {
+	var b strings.Builder
+	if err := clientConfigTpl.Execute(&b, cc); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
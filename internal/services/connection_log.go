@@ -0,0 +1,74 @@
+package services
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"wiretify/internal/database"
+	"wiretify/internal/models"
+)
+
+// ConnectionLog lưu sự kiện kết nối/ngắt/roaming của peer kèm IP và port nguồn,
+// và cập nhật endpoint hiện tại vào Peer.Endpoints
+type ConnectionLog struct{}
+
+func NewConnectionLog() *ConnectionLog {
+	return &ConnectionLog{}
+}
+
+// Record được đăng ký với PeerWatcher.Handle
+func (l *ConnectionLog) Record(ev StatusEvent) {
+	var event models.PeerEvent
+	switch ev.Type {
+	case StatusEventConnected:
+		event = models.PeerEvent{Type: models.PeerEventConnected, Message: "Connected from " + ev.Endpoint}
+	case StatusEventDisconnected:
+		event = models.PeerEvent{Type: models.PeerEventDisconnected, Message: "Disconnected"}
+		if ev.Endpoint != "" {
+			event.Message += ", last endpoint " + ev.Endpoint
+		}
+	case StatusEventEndpoint:
+		event = models.PeerEvent{Type: models.PeerEventRoamed, Message: fmt.Sprintf("Roamed from %s to %s", ev.PrevEndpoint, ev.Endpoint)}
+	default:
+		return
+	}
+
+	event.PeerID = ev.PeerID
+	event.CreatedAt = ev.Time.UTC()
+	event.SourceIP, event.SourcePort = splitEndpoint(ev.Endpoint)
+	if err := database.DB.Create(&event).Error; err != nil {
+		log.Printf("Warning: failed to record %s event for peer %d: %v", event.Type, ev.PeerID, err)
+	}
+
+	if ev.Type != StatusEventDisconnected && ev.Endpoint != "" {
+		var peer models.Peer
+		database.DB.Limit(1).Find(&peer, ev.PeerID)
+
+		// UpdateColumn để không đụng tới updated_at
+		if err := database.DB.Model(&models.Peer{}).Where("id = ?", ev.PeerID).UpdateColumn("endpoints", ev.Endpoint).Error; err != nil {
+			log.Printf("Warning: failed to store endpoint for peer %d: %v", ev.PeerID, err)
+			return
+		}
+
+		// Mesh member không có endpoint cố định: các member khác cần config mới với endpoint vừa đổi
+		if peer.Mesh {
+			old := MeshEndpoint(peer)
+			peer.Endpoints = ev.Endpoint
+			if MeshEndpoint(peer) != old {
+				if err := MarkMeshOutdated(database.DB, peer.NetworkID, peer.ID); err != nil {
+					log.Printf("Warning: failed to mark mesh configs outdated after peer %d moved: %v", ev.PeerID, err)
+				}
+			}
+		}
+	}
+}
+
+func splitEndpoint(endpoint string) (string, int) {
+	host, port, err := net.SplitHostPort(endpoint)
+	if err != nil {
+		return "", 0
+	}
+	p, _ := strconv.Atoi(port)
+	return host, p
+}
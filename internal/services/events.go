@@ -0,0 +1,197 @@
+package services
+
+import (
+	"log"
+	"sync"
+	"time"
+	"wiretify/internal/database"
+	"wiretify/internal/models"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// Các loại sự kiện trạng thái peer gửi tới client qua /api/events
+const (
+	StatusEventConnected    = "connected"
+	StatusEventDisconnected = "disconnected"
+	StatusEventEndpoint     = "endpoint"
+	StatusEventTraffic      = "traffic"
+)
+
+// StatusEvent là một thay đổi trạng thái của peer quan sát được từ kernel
+type StatusEvent struct {
+	Type          string     `json:"type"`
+	PeerID        uint       `json:"peer_id"`
+	PublicKey     string     `json:"public_key"`
+	Time          time.Time  `json:"time"`
+	Connected     bool       `json:"connected"`
+	Endpoint      string     `json:"endpoint,omitempty"`
+	PrevEndpoint  string     `json:"prev_endpoint,omitempty"`
+	LastHandshake *time.Time `json:"last_handshake,omitempty"`
+	RxBytes       int64      `json:"rx_bytes"`
+	TxBytes       int64      `json:"tx_bytes"`
+	RxDelta       int64      `json:"rx_delta,omitempty"`
+	TxDelta       int64      `json:"tx_delta,omitempty"`
+}
+
+type watchedPeer struct {
+	connected bool
+	endpoint  string
+	rx, tx    int64
+}
+
+// PeerWatcher theo dõi device qua DeviceMonitor và phát StatusEvent tới các subscriber,
+// đồng thời giữ snapshot kernel mới nhất để ListPeers không phải đọc lại device.
+type PeerWatcher struct {
+	timeout time.Duration // HANDSHAKE_TIMEOUT
+
+	mu       sync.Mutex
+	state    map[string]watchedPeer // public key -> trạng thái ở lần poll trước
+	snapshot map[string]wgtypes.Peer
+	subs     map[chan StatusEvent]struct{}
+	handlers []func(StatusEvent)
+}
+
+func NewPeerWatcher(timeout time.Duration) *PeerWatcher {
+	return &PeerWatcher{
+		timeout: timeout,
+		state:   make(map[string]watchedPeer),
+		subs:    make(map[chan StatusEvent]struct{}),
+	}
+}
+
+// Subscribe trả về kênh nhận sự kiện và hàm huỷ đăng ký.
+// Subscriber đọc chậm sẽ bị bỏ qua sự kiện thay vì làm nghẽn watcher.
+func (w *PeerWatcher) Subscribe() (<-chan StatusEvent, func()) {
+	ch := make(chan StatusEvent, 64)
+	w.mu.Lock()
+	w.subs[ch] = struct{}{}
+	w.mu.Unlock()
+
+	return ch, func() {
+		w.mu.Lock()
+		if _, ok := w.subs[ch]; ok {
+			delete(w.subs, ch)
+			close(ch)
+		}
+		w.mu.Unlock()
+	}
+}
+
+// Handle đăng ký hàm được gọi đồng bộ cho mọi sự kiện (không bị bỏ qua như Subscribe); nên gọi trước khi monitor chạy
+func (w *PeerWatcher) Handle(fn func(StatusEvent)) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.handlers = append(w.handlers, fn)
+}
+
+// Snapshot trả về trạng thái device ở lần poll gần nhất (nil nếu chưa poll lần nào)
+func (w *PeerWatcher) Snapshot() map[string]wgtypes.Peer {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.snapshot
+}
+
+// Observe là DeviceObserver, được DeviceMonitor gọi sau mỗi lần poll
+func (w *PeerWatcher) Observe(now time.Time, devicePeers map[string]wgtypes.Peer) {
+	var peers []models.Peer
+	if err := database.DB.Select("id", "public_key").Find(&peers).Error; err != nil {
+		log.Printf("Warning: peer watcher failed to load peers: %v", err)
+		return
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.snapshot = devicePeers
+
+	seen := make(map[string]bool, len(peers))
+	for _, p := range peers {
+		seen[p.PublicKey] = true
+		prev, known := w.state[p.PublicKey]
+
+		wgp, ok := devicePeers[p.PublicKey]
+		if !ok {
+			// Peer bị gỡ khỏi device (disable/xoá)
+			if known && prev.connected {
+				w.publish(StatusEvent{Type: StatusEventDisconnected, PeerID: p.ID, PublicKey: p.PublicKey, Time: now, Endpoint: prev.endpoint})
+			}
+			delete(w.state, p.PublicKey)
+			continue
+		}
+
+		cur := watchedPeer{
+			connected: IsConnected(wgp.LastHandshakeTime, now, w.timeout),
+			rx:        wgp.ReceiveBytes,
+			tx:        wgp.TransmitBytes,
+		}
+		if wgp.Endpoint != nil {
+			cur.endpoint = wgp.Endpoint.String()
+		}
+		w.state[p.PublicKey] = cur
+
+		base := StatusEvent{
+			PeerID:    p.ID,
+			PublicKey: p.PublicKey,
+			Time:      now,
+			Connected: cur.connected,
+			Endpoint:  cur.endpoint,
+			RxBytes:   cur.rx,
+			TxBytes:   cur.tx,
+		}
+		if !wgp.LastHandshakeTime.IsZero() {
+			hs := wgp.LastHandshakeTime
+			base.LastHandshake = &hs
+		}
+
+		if !known {
+			// Lần đầu thấy peer: chỉ báo nếu đang kết nối, chưa có delta
+			if cur.connected {
+				ev := base
+				ev.Type = StatusEventConnected
+				w.publish(ev)
+			}
+			continue
+		}
+
+		if cur.connected != prev.connected {
+			ev := base
+			ev.Type = StatusEventDisconnected
+			if cur.connected {
+				ev.Type = StatusEventConnected
+			}
+			w.publish(ev)
+		}
+		if cur.endpoint != prev.endpoint && prev.endpoint != "" && cur.endpoint != "" {
+			ev := base
+			ev.Type = StatusEventEndpoint
+			ev.PrevEndpoint = prev.endpoint
+			w.publish(ev)
+		}
+		if rx, tx := counterDelta(prev.rx, cur.rx), counterDelta(prev.tx, cur.tx); rx > 0 || tx > 0 {
+			ev := base
+			ev.Type = StatusEventTraffic
+			ev.RxDelta = rx
+			ev.TxDelta = tx
+			w.publish(ev)
+		}
+	}
+
+	for key := range w.state {
+		if !seen[key] {
+			delete(w.state, key)
+		}
+	}
+}
+
+// publish phải được gọi khi đang giữ w.mu
+func (w *PeerWatcher) publish(ev StatusEvent) {
+	for _, fn := range w.handlers {
+		fn(ev)
+	}
+	for ch := range w.subs {
+		select {
+		case ch <- ev:
+		default:
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package services
+
+import (
+	"context"
+	"log"
+	"time"
+	"wiretify/internal/database"
+	"wiretify/internal/models"
+)
+
+const expiryCheckInterval = time.Minute
+
+// expiredPeers chọn peer đã bị tắt do hết hạn từ trước một thời điểm
+const expiredPeers = "enabled = ? AND disabled_reason = ? AND expires_at <= ?"
+
+// ExpiryScheduler chạy nền: disable peer khi tới ExpiresAt và xoá hẳn sau thời gian ân hạn
+type ExpiryScheduler struct {
+	peerSvc *PeerService
+	grace   time.Duration // 0: không tự động xoá
+}
+
+func NewExpiryScheduler(peerSvc *PeerService, grace time.Duration) *ExpiryScheduler {
+	return &ExpiryScheduler{peerSvc: peerSvc, grace: grace}
+}
+
+// Run kiểm tra hạn của peer mỗi phút cho tới khi ctx bị huỷ
+func (s *ExpiryScheduler) Run(ctx context.Context) {
+	ticker := time.NewTicker(expiryCheckInterval)
+	defer ticker.Stop()
+
+	s.check()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.check()
+		}
+	}
+}
+
+func (s *ExpiryScheduler) check() {
+	// SQLite lưu thời gian dạng chuỗi nên luôn so sánh ở UTC
+	now := time.Now().UTC()
+
+	var expired []models.Peer
+	database.DB.Where("enabled = ? AND expires_at IS NOT NULL AND expires_at <= ?", true, now).Find(&expired)
+	for _, p := range expired {
+		disabled, err := s.peerSvc.expire(p.ID, now)
+		if err != nil {
+			log.Printf("Warning: failed to disable expired peer %s: %v", p.Name, err)
+			continue
+		}
+		if disabled {
+			log.Printf("Peer %s expired at %s, disabled", p.Name, p.ExpiresAt.Format(time.RFC3339))
+			RecordPeerEvent(p.ID, models.PeerEventExpired, "Peer expired at "+p.ExpiresAt.Format(time.RFC3339))
+		}
+	}
+
+	if s.grace <= 0 {
+		return
+	}
+
+	var stale []models.Peer
+	database.DB.Where(expiredPeers, false, models.DisabledReasonExpired, now.Add(-s.grace)).Find(&stale)
+	for _, p := range stale {
+		deleted, err := s.peerSvc.purgeExpired(p.ID, now.Add(-s.grace))
+		if err != nil {
+			log.Printf("Warning: failed to delete expired peer %s: %v", p.Name, err)
+			continue
+		}
+		if deleted {
+			log.Printf("Peer %s expired more than %s ago, deleted", p.Name, s.grace)
+		}
+	}
+}
+
+// expire tắt peer nếu khi đọc lại trong transaction nó vẫn đang bật và đã hết hạn,
+// để không tắt peer vừa được gia hạn sau lần quét
+func (s *PeerService) expire(peerID uint, now time.Time) (bool, error) {
+	disabled := false
+	err := s.change(func(ch *peerChange) error {
+		var peer models.Peer
+		if err := ch.tx.Where("enabled = ? AND expires_at IS NOT NULL AND expires_at <= ?", true, now).Limit(1).Find(&peer, peerID).Error; err != nil {
+			return err
+		}
+		if peer.ID == 0 {
+			return nil
+		}
+		disabled = true
+		return s.setEnabled(ch, &peer, false, models.DisabledReasonExpired)
+	})
+	return disabled, err
+}
+
+// purgeExpired xoá hẳn peer nếu khi đọc lại trong transaction nó vẫn bị tắt do hết hạn từ trước cutoff
+func (s *PeerService) purgeExpired(peerID uint, cutoff time.Time) (bool, error) {
+	deleted := false
+	err := s.change(func(ch *peerChange) error {
+		var peer models.Peer
+		if err := ch.tx.Where(expiredPeers, false, models.DisabledReasonExpired, cutoff).Limit(1).Find(&peer, peerID).Error; err != nil {
+			return err
+		}
+		if peer.ID == 0 {
+			return nil
+		}
+		deleted = true
+		return s.deletePeer(ch, peer, true)
+	})
+	return deleted, err
+}
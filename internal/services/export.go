@@ -0,0 +1,115 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"wiretify/internal/database"
+	"wiretify/internal/models"
+
+	"github.com/coreos/go-iptables/iptables"
+)
+
+// ExportWGQuick dựng file cấu hình wg-quick đầy đủ phía server cho một mạng: interface, mọi peer đang bật
+// và PostUp/PostDown tái tạo NAT/FORWARD/port forward rules và chain ACL như NetworkService,
+// để có thể chạy `wg-quick up` khi Wiretify không hoạt động.
+func ExportWGQuick(n models.Network) (string, error) {
+	key, err := loadServerKey(n)
+	if err != nil {
+		return "", fmt.Errorf("failed to load server key: %v", err)
+	}
+
+	var peers []models.Peer
+	if err := database.DB.Where("network_id = ?", n.ID).Order("id").Find(&peers).Error; err != nil {
+		return "", err
+	}
+	var portForwards []models.PortForward
+	if err := database.DB.Where("network_id = ?", n.ID).Order("id").Find(&portForwards).Error; err != nil {
+		return "", err
+	}
+	acl, err := CompileACL(database.DB, n.ID)
+	if err != nil {
+		return "", err
+	}
+
+	// Port forward tới peer đang bị disable không có rule trên kernel
+	suspended := make(map[string]bool)
+	for _, p := range peers {
+		if !p.Enabled {
+			suspended[p.IP()] = true
+		}
+	}
+
+	type familyRules struct {
+		cmd    string
+		sysctl string
+		rules  []firewallRule
+		acl    []firewallRule // Nội dung chain ACL
+	}
+	families := []familyRules{{cmd: "iptables", sysctl: "net.ipv4.ip_forward=1", rules: interfaceRules(n.InterfaceName, n.Address), acl: acl[iptables.ProtocolIPv4]}}
+	for _, pf := range portForwards {
+		if !suspended[pf.TargetNode] {
+			families[0].rules = append(families[0].rules, portForwardRules(pf.PublicPort, pf.TargetNode, pf.TargetPort, pf.Protocol)...)
+		}
+	}
+	if n.Address6 != "" {
+		families = append(families, familyRules{cmd: "ip6tables", sysctl: "net.ipv6.conf.all.forwarding=1", rules: interfaceRules(n.InterfaceName, n.Address6), acl: acl[iptables.ProtocolIPv6]})
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "# Wiretify network %s (%s), exported %s\n", n.Name, n.InterfaceName, time.Now().UTC().Format(time.RFC3339))
+	b.WriteString("[Interface]\n")
+	addresses := []string{n.Address}
+	if n.Address6 != "" {
+		addresses = append(addresses, n.Address6)
+	}
+	fmt.Fprintf(&b, "Address = %s\n", strings.Join(addresses, ", "))
+	fmt.Fprintf(&b, "ListenPort = %d\n", n.ListenPort)
+	fmt.Fprintf(&b, "PrivateKey = %s\n", key.String())
+	for _, f := range families {
+		fmt.Fprintf(&b, "PostUp = sysctl -w %s\n", f.sysctl)
+		for _, r := range f.rules {
+			fmt.Fprintf(&b, "PostUp = %s\n", r.command(f.cmd, "-A"))
+		}
+		fmt.Fprintf(&b, "PostUp = %s -t filter -N %s\n", f.cmd, ACLChain(n.InterfaceName))
+		for _, r := range f.acl {
+			fmt.Fprintf(&b, "PostUp = %s\n", r.command(f.cmd, "-A"))
+		}
+		fmt.Fprintf(&b, "PostUp = %s\n", aclHookRule(n.InterfaceName).command(f.cmd, "-I"))
+	}
+	for _, f := range families {
+		fmt.Fprintf(&b, "PostDown = %s\n", aclHookRule(n.InterfaceName).command(f.cmd, "-D"))
+		fmt.Fprintf(&b, "PostDown = %s -t filter -F %s\n", f.cmd, ACLChain(n.InterfaceName))
+		fmt.Fprintf(&b, "PostDown = %s -t filter -X %s\n", f.cmd, ACLChain(n.InterfaceName))
+		for i := len(f.rules) - 1; i >= 0; i-- {
+			fmt.Fprintf(&b, "PostDown = %s\n", f.rules[i].command(f.cmd, "-D"))
+		}
+	}
+
+	for _, p := range peers {
+		if !p.Enabled {
+			continue
+		}
+		allowedIPs, err := peerAllowedIPs(p)
+		if err != nil {
+			continue
+		}
+		cidrs := make([]string, len(allowedIPs))
+		for i, ipNet := range allowedIPs {
+			cidrs[i] = ipNet.String()
+		}
+
+		fmt.Fprintf(&b, "\n# %s\n[Peer]\n", p.Name)
+		fmt.Fprintf(&b, "PublicKey = %s\n", p.PublicKey)
+		if p.PresharedKey != "" {
+			fmt.Fprintf(&b, "PresharedKey = %s\n", p.PresharedKey)
+		}
+		fmt.Fprintf(&b, "AllowedIPs = %s\n", strings.Join(cidrs, ", "))
+	}
+	return b.String(), nil
+}
+
+// command trả về dòng lệnh iptables tương ứng với rule, vd. "iptables -t nat -A POSTROUTING ..."
+func (r firewallRule) command(cmd, action string) string {
+	return fmt.Sprintf("%s -t %s %s %s %s", cmd, r.table, action, r.chain, strings.Join(r.spec, " "))
+}
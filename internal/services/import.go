@@ -0,0 +1,334 @@
+package services
+
+import (
+	"fmt"
+	"log"
+	"net/netip"
+	"strings"
+	"wiretify/internal/database"
+	"wiretify/internal/models"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+	"gorm.io/gorm"
+)
+
+// ImportOptions điều khiển việc import một cấu hình wg-quick vào một mạng
+type ImportOptions struct {
+	AdoptKey bool // Dùng PrivateKey của [Interface] làm server key để client cũ không phải đổi config
+	DryRun   bool // Chỉ kiểm tra, không ghi gì
+}
+
+// ImportConflict là một [Peer] bị bỏ qua và lý do
+type ImportConflict struct {
+	Line      int    `json:"line"`
+	Name      string `json:"name"`
+	PublicKey string `json:"public_key"`
+	Reason    string `json:"reason"`
+}
+
+// ImportError là lỗi do nội dung cấu hình hoặc tham số import (không phải lỗi DB/kernel)
+type ImportError struct {
+	Reason string
+}
+
+func (e *ImportError) Error() string { return e.Reason }
+
+// ImportResult mô tả kết quả import; các peer có conflict bị bỏ qua, phần còn lại vẫn được tạo
+type ImportResult struct {
+	Imported   []models.Peer    `json:"imported"`
+	Conflicts  []ImportConflict `json:"conflicts"`
+	Warnings   []string         `json:"warnings"`
+	KeyAdopted bool             `json:"key_adopted"`
+	DryRun     bool             `json:"dry_run"`
+}
+
+// ImportWGQuick tạo peer từ các section [Peer] của file wg-quick trong mạng networkID.
+// Peer có key trùng, địa chỉ nằm ngoài pool hoặc đã có người dùng được báo trong Conflicts
+// thay vì làm hỏng cả lần import. Các peer hợp lệ được tạo trong một transaction rồi sync một lần.
+func (s *PeerService) ImportWGQuick(networkID uint, conf *WGQuickConfig, opts ImportOptions) (*ImportResult, error) {
+	wn := s.networks.Get(networkID)
+	if wn == nil {
+		return nil, &ImportError{fmt.Sprintf("network %d not found", networkID)}
+	}
+
+	result := &ImportResult{Imported: []models.Peer{}, Conflicts: []ImportConflict{}, Warnings: []string{}, DryRun: opts.DryRun}
+
+	var adoptedKey *wgtypes.Key
+	if opts.AdoptKey {
+		if conf.Interface.PrivateKey == "" {
+			return nil, &ImportError{"the configuration has no [Interface] PrivateKey to adopt"}
+		}
+		key, err := wgtypes.ParseKey(conf.Interface.PrivateKey)
+		if err != nil {
+			return nil, &ImportError{fmt.Sprintf("invalid [Interface] PrivateKey: %v", err)}
+		}
+		adoptedKey = &key
+	}
+	result.Warnings = append(result.Warnings, interfaceWarnings(wn, conf.Interface)...)
+
+	// plan đối chiếu các [Peer] với key, tên và địa chỉ đang dùng trong db, chia thành peer sẽ tạo và conflict
+	plan := func(db *gorm.DB) error {
+		state, err := newImportState(db, s.networks)
+		if err != nil {
+			return err
+		}
+		if adoptedKey != nil {
+			state.keys[adoptedKey.PublicKey().String()] = true
+		}
+
+		result.Imported, result.Conflicts = []models.Peer{}, []ImportConflict{}
+		for _, qp := range conf.Peers {
+			peer, reason := state.peerFromWGQuick(wn, qp)
+			if reason != "" {
+				result.Conflicts = append(result.Conflicts, ImportConflict{Line: qp.Line, Name: qp.Name, PublicKey: qp.PublicKey, Reason: reason})
+				continue
+			}
+			result.Imported = append(result.Imported, peer)
+		}
+		return nil
+	}
+
+	if opts.DryRun {
+		if err := plan(database.DB); err != nil {
+			return nil, err
+		}
+		result.KeyAdopted = adoptedKey != nil
+		return result, nil
+	}
+
+	err := s.change(func(ch *peerChange) error {
+		tx := ch.tx
+		// Đối chiếu trong transaction (đang giữ mu) để peer được tạo đồng thời không lọt qua kiểm tra trùng
+		if err := plan(tx); err != nil {
+			return err
+		}
+		if len(result.Imported) == 0 && adoptedKey == nil {
+			return nil
+		}
+
+		if adoptedKey != nil {
+			// Peer đang có của mạng dùng server key cũ nên cần tải lại config
+			if err := tx.Model(&models.Peer{}).Where("network_id = ?", wn.ID).Update("config_outdated", true).Error; err != nil {
+				return err
+			}
+			if err := storeServerKey(tx, wn.ID, *adoptedKey); err != nil {
+				return err
+			}
+		}
+		for i := range result.Imported {
+			peer := &result.Imported[i]
+			if err := tx.Create(peer).Error; err != nil {
+				return fmt.Errorf("failed to create peer %s: %w", peer.Name, err)
+			}
+			// use_as_exit_node có default:true nên giá trị false bị bỏ qua khi Create
+			if err := tx.Model(peer).UpdateColumn("use_as_exit_node", false).Error; err != nil {
+				return fmt.Errorf("failed to create peer %s: %w", peer.Name, err)
+			}
+			peer.UseAsExitNode = false
+		}
+		ch.touch(wn.ID)
+		if adoptedKey != nil {
+			// WGService đọc server key qua database.DB nên chỉ thấy key mới sau khi commit
+			ch.afterCommit(func() {
+				if _, err := s.syncNetwork(database.DB, wn); err != nil {
+					result.Warnings = append(result.Warnings, fmt.Sprintf("the adopted key was saved but applying it to %s failed: %v", wn.InterfaceName, err))
+				}
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	result.KeyAdopted = adoptedKey != nil
+
+	for _, p := range result.Imported {
+		RecordPeerEvent(p.ID, models.PeerEventImported, "Imported from wg-quick configuration")
+	}
+	log.Printf("Imported %d peers into network %s (%d conflicts)", len(result.Imported), wn.Name, len(result.Conflicts))
+
+	return result, nil
+}
+
+// interfaceWarnings so sánh [Interface] với mạng đích để nhắc những khác biệt mà import không tự sửa
+func interfaceWarnings(wn *WGNetwork, iface WGQuickInterface) []string {
+	var warnings []string
+	for _, addr := range iface.Address {
+		p, err := netip.ParsePrefix(addr)
+		if err != nil {
+			warnings = append(warnings, fmt.Sprintf("ignoring invalid interface address %s", addr))
+			continue
+		}
+		pool := wn.Address
+		if p.Addr().Is6() {
+			pool = wn.Address6
+		}
+		if pp, err := netip.ParsePrefix(pool); err != nil || pp.Masked() != p.Masked() {
+			warnings = append(warnings, fmt.Sprintf("interface address %s does not match the %s subnet %s", addr, wn.Name, pool))
+		}
+	}
+	if iface.ListenPort != 0 && iface.ListenPort != wn.ListenPort {
+		warnings = append(warnings, fmt.Sprintf("ListenPort %d differs from %s (%d), clients need their endpoint port updated", iface.ListenPort, wn.Name, wn.ListenPort))
+	}
+	return warnings
+}
+
+// importState giữ các key, địa chỉ và tên đã dùng (trong DB và trong file đang import)
+type importState struct {
+	keys    map[string]bool
+	names   map[peerName]bool
+	addrs   map[netip.Addr]string
+	subnets map[netip.Prefix]string
+}
+
+// peerName là tên peer trong một mạng
+type peerName struct {
+	networkID uint
+	name      string
+}
+
+func newImportState(db *gorm.DB, networks *NetworkManager) (*importState, error) {
+	st := &importState{
+		keys:    make(map[string]bool),
+		names:   make(map[peerName]bool),
+		addrs:   make(map[netip.Addr]string),
+		subnets: make(map[netip.Prefix]string),
+	}
+
+	for _, wn := range networks.All() {
+		for _, pool := range []string{wn.Address, wn.Address6} {
+			if p, err := netip.ParsePrefix(pool); err == nil {
+				st.addrs[p.Addr().Unmap()] = "the server"
+				st.subnets[p.Masked()] = "the VPN subnet of " + wn.Name
+			}
+		}
+		if wn.WG != nil {
+			if pub, _, _ := wn.WG.GetServerConfig(); pub != "" {
+				st.keys[pub] = true
+			}
+		}
+	}
+
+	// Unique index tính cả peer đã soft-delete; tên chỉ unique trong mỗi mạng
+	var peers []models.Peer
+	if err := db.Unscoped().Find(&peers).Error; err != nil {
+		return nil, err
+	}
+	for _, p := range peers {
+		st.keys[p.PublicKey] = true
+		if p.PreviousPublicKey != "" {
+			st.keys[p.PreviousPublicKey] = true
+		}
+		st.names[peerName{p.NetworkID, p.Name}] = true
+		if p.DeletedAt.Valid {
+			continue
+		}
+		st.claim(p)
+	}
+	return st, nil
+}
+
+// claim đánh dấu địa chỉ và subnet của peer là đã dùng
+func (st *importState) claim(p models.Peer) {
+	for _, cidr := range []string{p.AllowedIPs, p.AllowedIPs6} {
+		if pp, err := netip.ParsePrefix(cidr); err == nil {
+			st.addrs[pp.Addr().Unmap()] = "peer " + p.Name
+		}
+	}
+	for _, cidr := range p.RoutedSubnets {
+		if pp, err := netip.ParsePrefix(cidr); err == nil {
+			st.subnets[pp.Masked()] = "peer " + p.Name
+		}
+	}
+}
+
+// peerFromWGQuick dựng models.Peer từ một [Peer]; trả về lý do nếu peer không import được
+func (st *importState) peerFromWGQuick(wn *WGNetwork, qp WGQuickPeer) (models.Peer, string) {
+	key, err := wgtypes.ParseKey(qp.PublicKey)
+	if err != nil {
+		return models.Peer{}, "invalid public key"
+	}
+	pub := key.String()
+	if st.keys[pub] {
+		return models.Peer{}, "public key is already in use"
+	}
+
+	var psk string
+	if qp.PresharedKey != "" {
+		k, err := wgtypes.ParseKey(qp.PresharedKey)
+		if err != nil {
+			return models.Peer{}, "invalid preshared key"
+		}
+		psk = k.String()
+	}
+
+	pool, _ := netip.ParsePrefix(wn.Address)
+	pool6, _ := netip.ParsePrefix(wn.Address6)
+
+	// Split tunnel như peer tạo qua API; AllowedIPs phía server không cho biết client route những gì
+	peer := models.Peer{NetworkID: wn.ID, PublicKey: pub, PresharedKey: psk, Enabled: true, RoutedSubnets: []string{}}
+	for _, raw := range qp.AllowedIPs {
+		p, err := netip.ParsePrefix(raw)
+		if err != nil {
+			return models.Peer{}, fmt.Sprintf("invalid AllowedIPs entry %s", raw)
+		}
+		p = p.Masked()
+		addr := p.Addr().Unmap()
+
+		// Địa chỉ host trong pool của mạng là IP VPN của peer, còn lại là subnet LAN phía sau peer.
+		// IP VPN được kiểm tra như khi tạo peer (không nhận địa chỉ network/broadcast của pool).
+		switch {
+		case peer.AllowedIPs == "" && addr.Is4() && p.IsSingleIP() && pool.Contains(addr):
+			if owner, ok := st.addrs[addr]; ok {
+				return models.Peer{}, fmt.Sprintf("address %s is already used by %s", addr, owner)
+			}
+			if peer.AllowedIPs, err = ValidatePeerAddress(p.String(), wn.Address, nil); err != nil {
+				return models.Peer{}, err.Error()
+			}
+		case peer.AllowedIPs6 == "" && addr.Is6() && p.IsSingleIP() && pool6.IsValid() && pool6.Contains(addr):
+			if owner, ok := st.addrs[addr]; ok {
+				return models.Peer{}, fmt.Sprintf("address %s is already used by %s", addr, owner)
+			}
+			if peer.AllowedIPs6, err = ValidatePeerAddress(p.String(), wn.Address6, nil); err != nil {
+				return models.Peer{}, err.Error()
+			}
+		default:
+			if p.Bits() == 0 {
+				return models.Peer{}, fmt.Sprintf("%s would route all traffic to this peer", p)
+			}
+			for other, owner := range st.subnets {
+				if other.Overlaps(p) {
+					return models.Peer{}, fmt.Sprintf("%s overlaps %s (%s)", p, other, owner)
+				}
+			}
+			for other, owner := range st.addrs {
+				if p.Contains(other) {
+					return models.Peer{}, fmt.Sprintf("%s overlaps %s (%s)", p, other, owner)
+				}
+			}
+			peer.RoutedSubnets = append(peer.RoutedSubnets, p.String())
+		}
+	}
+	if peer.AllowedIPs == "" {
+		return models.Peer{}, fmt.Sprintf("no host address inside the %s subnet %s", wn.Name, wn.Address)
+	}
+
+	peer.Name = st.uniqueName(wn.ID, qp.Name, pub)
+	st.keys[pub] = true
+	st.names[peerName{wn.ID, peer.Name}] = true
+	st.claim(peer)
+	return peer, ""
+}
+
+// uniqueName dùng tên trong comment (hoặc prefix của public key) và thêm hậu tố khi bị trùng trong mạng
+func (st *importState) uniqueName(networkID uint, name, pub string) string {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		name = "imported-" + strings.NewReplacer("/", "", "+", "").Replace(pub)[:8]
+	}
+	candidate := name
+	for i := 2; st.names[peerName{networkID, candidate}]; i++ {
+		candidate = fmt.Sprintf("%s-%d", name, i)
+	}
+	return candidate
+}
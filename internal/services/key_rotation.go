@@ -0,0 +1,98 @@
+package services
+
+import (
+	"fmt"
+	"log"
+	"time"
+	"wiretify/internal/models"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// RotateKeys thay keypair của peer, giữ nguyên IP, endpoint và port forward.
+// Peer được đọc lại trong transaction để không ghi đè thay đổi đồng thời.
+// privateKey rỗng khi key được sinh trên thiết bị (bring-your-own).
+// overlap > 0 giữ key cũ trên device tới khi key mới handshake hoặc hết overlap.
+func (s *PeerService) RotateKeys(peerID uint, publicKey, privateKey string, overlap time.Duration) (*models.Peer, error) {
+	var peer models.Peer
+	err := s.change(func(ch *peerChange) error {
+		if err := ch.tx.First(&peer, peerID).Error; err != nil {
+			return err
+		}
+		oldKey := peer.PublicKey
+
+		peer.PublicKey = publicKey
+		peer.PrivateKey = privateKey
+		peer.PreviousPublicKey = ""
+		peer.PreviousKeyExpiresAt = nil
+		peer.ConfigOutdated = true
+		if overlap > 0 {
+			until := time.Now().UTC().Add(overlap)
+			peer.PreviousPublicKey = oldKey
+			peer.PreviousKeyExpiresAt = &until
+		}
+
+		// Update qua struct để các field có serializer được xử lý đúng
+		if err := ch.tx.Model(&peer).
+			Select("public_key", "private_key", "previous_public_key", "previous_key_expires_at", "config_outdated").
+			Updates(&peer).Error; err != nil {
+			return err
+		}
+		ch.touch(peer.NetworkID)
+		// Các mesh member khác có public key của peer trong config
+		if peer.Mesh {
+			return MarkMeshOutdated(ch.tx, peer.NetworkID, peer.ID)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	message := "Key rotated"
+	if peer.PreviousKeyExpiresAt != nil {
+		message = fmt.Sprintf("Key rotated, previous key accepted until %s", peer.PreviousKeyExpiresAt.Format(time.RFC3339))
+	}
+	RecordPeerEvent(peer.ID, models.PeerEventKeyRotated, message)
+	return &peer, nil
+}
+
+// ObserveKeyRotations là DeviceObserver: kết thúc overlap window khi key mới đã handshake
+// hoặc khi hết hạn, rồi sync để gỡ key cũ khỏi device và chuyển AllowedIPs sang key mới.
+// Chạy trong transaction của PeerService để không xoá overlap của một lần rotate vừa commit.
+func (s *PeerService) ObserveKeyRotations(now time.Time, devicePeers map[string]wgtypes.Peer) {
+	err := s.change(func(ch *peerChange) error {
+		var peers []models.Peer
+		if err := ch.tx.Where("previous_public_key <> ''").Find(&peers).Error; err != nil {
+			return err
+		}
+
+		for _, p := range peers {
+			expired := p.PreviousKeyExpiresAt == nil || !now.Before(*p.PreviousKeyExpiresAt)
+			if wgp, ok := devicePeers[p.PublicKey]; !expired && (!ok || wgp.LastHandshakeTime.IsZero()) {
+				continue
+			}
+
+			if err := ch.tx.Model(&p).Updates(map[string]interface{}{
+				"previous_public_key":     "",
+				"previous_key_expires_at": nil,
+			}).Error; err != nil {
+				return fmt.Errorf("failed to finish key rotation for peer %s: %v", p.Name, err)
+			}
+			ch.touch(p.NetworkID)
+
+			name := p.Name
+			ch.afterCommit(func() {
+				if expired {
+					log.Printf("Key overlap window for peer %s expired, previous key removed", name)
+				} else {
+					log.Printf("Peer %s connected with its new key, previous key removed", name)
+				}
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("Warning: failed to finish pending key rotations: %v", err)
+	}
+}
@@ -0,0 +1,148 @@
+package services
+
+import (
+	"net"
+	"slices"
+	"strconv"
+	"wiretify/internal/database"
+	"wiretify/internal/models"
+
+	"gorm.io/gorm"
+)
+
+// DefaultMeshPort là ListenPort của mesh member khi không chỉ định
+const DefaultMeshPort = 51820
+
+// Các loại liên kết trong topology
+const (
+	MeshLinkHub     = "hub"     // Peer ↔ hub, mọi peer đang bật đều có
+	MeshLinkDirect  = "direct"  // Hai mesh member có [Peer] trực tiếp với nhau
+	MeshLinkRelayed = "relayed" // Hai mesh member chưa biết endpoint của nhau, đi qua hub
+)
+
+// MeshEndpoint trả về endpoint mà các mesh member khác dùng để kết nối trực tiếp tới peer:
+// endpoint cố định nếu có, nếu không thì IP mà hub thấy kèm MeshPort. Rỗng nếu chưa biết.
+func MeshEndpoint(p models.Peer) string {
+	if p.MeshEndpoint != "" {
+		return p.MeshEndpoint
+	}
+	if p.Endpoints == "" || p.MeshPort == 0 {
+		return p.Endpoints
+	}
+	host, _, err := net.SplitHostPort(p.Endpoints)
+	if err != nil {
+		return ""
+	}
+	return net.JoinHostPort(host, strconv.Itoa(p.MeshPort))
+}
+
+// MeshDirect cho biết hai mesh member có [Peer] trực tiếp với nhau không:
+// cần ít nhất một bên có endpoint để bên kia bắt đầu handshake
+func MeshDirect(a, b models.Peer) bool {
+	return MeshEndpoint(a) != "" || MeshEndpoint(b) != ""
+}
+
+// MeshAllowedIPs là các prefix đi thẳng tới mesh member: địa chỉ VPN và LAN phía sau nó
+func MeshAllowedIPs(p models.Peer) []string {
+	allowed := []string{p.AllowedIPs}
+	if p.AllowedIPs6 != "" {
+		allowed = append(allowed, p.AllowedIPs6)
+	}
+	return append(allowed, p.RoutedSubnets...)
+}
+
+// MeshMembers trả về các mesh member đang bật của mạng, trừ peer exceptID
+func MeshMembers(db *gorm.DB, networkID, exceptID uint) ([]models.Peer, error) {
+	var members []models.Peer
+	err := db.Where("network_id = ? AND mesh = ? AND enabled = ? AND id <> ?", networkID, true, true, exceptID).Order("id").Find(&members).Error
+	return members, err
+}
+
+// MarkMeshOutdated đánh dấu config của các mesh member khác cần tải lại vì mesh đã thay đổi
+func MarkMeshOutdated(db *gorm.DB, networkID, changedID uint) error {
+	return db.Model(&models.Peer{}).
+		Where("network_id = ? AND mesh = ? AND id <> ?", networkID, true, changedID).
+		Update("config_outdated", true).Error
+}
+
+// meshChanged cho biết thay đổi từ old sang p có làm config của các mesh member khác lỗi thời không
+func meshChanged(old, p models.Peer) bool {
+	if !old.Mesh && !p.Mesh {
+		return false
+	}
+	return old.Mesh != p.Mesh || old.Enabled != p.Enabled || old.PublicKey != p.PublicKey ||
+		MeshEndpoint(old) != MeshEndpoint(p) || !slices.Equal(MeshAllowedIPs(old), MeshAllowedIPs(p))
+}
+
+// MeshNode là một nút trong topology; hub có ID 0
+type MeshNode struct {
+	ID        uint   `json:"id"`
+	Name      string `json:"name"`
+	Address   string `json:"address"`
+	Endpoint  string `json:"endpoint,omitempty"`
+	Hub       bool   `json:"hub"`
+	Mesh      bool   `json:"mesh"`
+	Enabled   bool   `json:"enabled"`
+	Connected bool   `json:"connected"` // Có handshake gần đây với hub
+	PublicKey string `json:"-"`
+}
+
+type MeshLink struct {
+	From uint   `json:"from"`
+	To   uint   `json:"to"`
+	Type string `json:"type"` // hub, direct, relayed
+}
+
+// MeshTopology mô tả đầy đủ các liên kết của một mạng: hub ↔ peer và giữa các mesh member
+type MeshTopology struct {
+	NetworkID uint       `json:"network_id"`
+	Nodes     []MeshNode `json:"nodes"`
+	Links     []MeshLink `json:"links"`
+}
+
+// BuildMeshTopology dựng topology của mạng từ DB
+func BuildMeshTopology(wn *WGNetwork) (*MeshTopology, error) {
+	var peers []models.Peer
+	if err := database.DB.Where("network_id = ?", wn.ID).Order("id").Find(&peers).Error; err != nil {
+		return nil, err
+	}
+
+	hub := MeshNode{Name: wn.Name, Hub: true, Enabled: true, Connected: true}
+	if ip, _, err := net.ParseCIDR(wn.Address); err == nil {
+		hub.Address = ip.String()
+	}
+	if wn.WG != nil {
+		if _, host, port := wn.WG.GetServerConfig(); host != "" {
+			hub.Endpoint = net.JoinHostPort(host, strconv.Itoa(port))
+		}
+	}
+
+	topo := &MeshTopology{NetworkID: wn.ID, Nodes: []MeshNode{hub}, Links: []MeshLink{}}
+	var members []models.Peer
+	for _, p := range peers {
+		node := MeshNode{ID: p.ID, Name: p.Name, Address: p.IP(), Mesh: p.Mesh, Enabled: p.Enabled, PublicKey: p.PublicKey}
+		if p.Mesh {
+			node.Endpoint = MeshEndpoint(p)
+		}
+		topo.Nodes = append(topo.Nodes, node)
+
+		if !p.Enabled {
+			continue
+		}
+		topo.Links = append(topo.Links, MeshLink{From: 0, To: p.ID, Type: MeshLinkHub})
+		if p.Mesh {
+			members = append(members, p)
+		}
+	}
+
+	for i, a := range members {
+		for _, b := range members[i+1:] {
+			link := MeshLink{From: a.ID, To: b.ID, Type: MeshLinkRelayed}
+			if MeshDirect(a, b) {
+				link.Type = MeshLinkDirect
+			}
+			topo.Links = append(topo.Links, link)
+		}
+	}
+	return topo, nil
+}
@@ -0,0 +1,68 @@
+package services
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// DeviceObserver nhận trạng thái kernel của các peer (theo public key) sau mỗi lần poll
+type DeviceObserver func(now time.Time, devicePeers map[string]wgtypes.Peer)
+
+// DeviceMonitor poll các WireGuard device định kỳ và chia sẻ kết quả cho các observer,
+// để các tác vụ nền (thống kê, quota...) không phải tự đọc kernel.
+type DeviceMonitor struct {
+	networks  *NetworkManager
+	interval  time.Duration
+	mu        sync.Mutex
+	observers []DeviceObserver
+}
+
+func NewDeviceMonitor(networks *NetworkManager, interval time.Duration) *DeviceMonitor {
+	if interval <= 0 {
+		interval = 10 * time.Second
+	}
+	return &DeviceMonitor{networks: networks, interval: interval}
+}
+
+// Subscribe đăng ký một observer; nên gọi trước Run
+func (m *DeviceMonitor) Subscribe(fn DeviceObserver) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.observers = append(m.observers, fn)
+}
+
+// Run poll device cho tới khi ctx bị huỷ
+func (m *DeviceMonitor) Run(ctx context.Context) {
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			m.poll()
+		}
+	}
+}
+
+func (m *DeviceMonitor) poll() {
+	devicePeers, err := m.networks.DevicePeers()
+	if err != nil {
+		log.Printf("Warning: device monitor failed to read peers: %v", err)
+		return
+	}
+
+	m.mu.Lock()
+	observers := append([]DeviceObserver(nil), m.observers...)
+	m.mu.Unlock()
+
+	now := time.Now()
+	for _, fn := range observers {
+		fn(now, devicePeers)
+	}
+}
@@ -3,23 +3,27 @@ package services
 import (
 	"fmt"
 	"log"
+	"net"
 	"os/exec"
-	"wiretify/internal/config"
+	"strings"
+	"wiretify/internal/models"
 
 	"github.com/coreos/go-iptables/iptables"
 	"github.com/vishvananda/netlink"
 )
 
+// NetworkService quản lý interface, route và firewall của một mạng.
+// Port forward (DNAT trên IP public) không phụ thuộc interface nên dùng được từ mạng bất kỳ.
 type NetworkService struct {
-	cfg *config.Config
+	network models.Network
 }
 
-func NewNetworkService(cfg *config.Config) *NetworkService {
-	return &NetworkService{cfg: cfg}
+func NewNetworkService(network models.Network) *NetworkService {
+	return &NetworkService{network: network}
 }
 
 func (s *NetworkService) SetupInterface() error {
-	linkName := s.cfg.InterfaceName
+	linkName := s.network.InterfaceName
 	la := netlink.NewLinkAttrs()
 	la.Name = linkName
 
@@ -42,71 +46,259 @@ func (s *NetworkService) SetupInterface() error {
 	}
 
 	// Gán IP
-	addr, err := netlink.ParseAddr(s.cfg.Address)
+	addr, err := netlink.ParseAddr(s.network.Address)
 	if err != nil {
-		return fmt.Errorf("invalid address %s: %v", s.cfg.Address, err)
+		return fmt.Errorf("invalid address %s: %v", s.network.Address, err)
 	}
 
 	if err := netlink.AddrAdd(wgLink, addr); err != nil {
 		return fmt.Errorf("failed to add address to %s: %v", linkName, err)
 	}
 
+	// Gán IPv6 (dual-stack) nếu được cấu hình
+	if s.network.Address6 != "" {
+		addr6, err := netlink.ParseAddr(s.network.Address6)
+		if err != nil {
+			return fmt.Errorf("invalid IPv6 address %s: %v", s.network.Address6, err)
+		}
+		if err := netlink.AddrAdd(wgLink, addr6); err != nil {
+			return fmt.Errorf("failed to add IPv6 address to %s: %v", linkName, err)
+		}
+	}
+
 	// Bring up
 	if err := netlink.LinkSetUp(wgLink); err != nil {
 		return fmt.Errorf("failed to set %s UP: %v", linkName, err)
 	}
 
-	log.Printf("Interface %s initialized with address %s", linkName, s.cfg.Address)
+	if s.network.Address6 != "" {
+		log.Printf("Interface %s initialized with addresses %s, %s", linkName, s.network.Address, s.network.Address6)
+	} else {
+		log.Printf("Interface %s initialized with address %s", linkName, s.network.Address)
+	}
+	return nil
+}
+
+// routeProtocol đánh dấu các route do Wiretify tạo để SyncRoutes không đụng tới route khác trên interface
+const routeProtocol netlink.RouteProtocol = 0x57
+
+// SyncRoutes đảm bảo mỗi subnet LAN của peer đang bật có một kernel route qua interface WireGuard,
+// đồng thời xoá các route Wiretify đã tạo nhưng không còn cần thiết.
+func (s *NetworkService) SyncRoutes(peers []models.Peer) error {
+	link, err := netlink.LinkByName(s.network.InterfaceName)
+	if err != nil {
+		return fmt.Errorf("failed to find interface %s: %v", s.network.InterfaceName, err)
+	}
+
+	desired := make(map[string]*net.IPNet)
+	for _, p := range peers {
+		if !p.Enabled {
+			continue
+		}
+		for _, cidr := range p.RoutedSubnets {
+			_, dst, err := net.ParseCIDR(cidr)
+			if err != nil {
+				log.Printf("Skip invalid routed subnet %s of peer %s: %v", cidr, p.Name, err)
+				continue
+			}
+			desired[dst.String()] = dst
+		}
+	}
+
+	filter := &netlink.Route{LinkIndex: link.Attrs().Index, Protocol: routeProtocol}
+	existing, err := netlink.RouteListFiltered(netlink.FAMILY_ALL, filter, netlink.RT_FILTER_OIF|netlink.RT_FILTER_PROTOCOL)
+	if err != nil {
+		return fmt.Errorf("failed to list routes on %s: %v", s.network.InterfaceName, err)
+	}
+	for _, r := range existing {
+		if r.Dst == nil {
+			continue
+		}
+		if _, ok := desired[r.Dst.String()]; ok {
+			continue
+		}
+		if err := netlink.RouteDel(&r); err != nil {
+			log.Printf("Warning: failed to delete route %s via %s: %v", r.Dst, s.network.InterfaceName, err)
+		}
+	}
+
+	for _, dst := range desired {
+		route := &netlink.Route{
+			LinkIndex: link.Attrs().Index,
+			Dst:       dst,
+			Scope:     netlink.SCOPE_LINK,
+			Protocol:  routeProtocol,
+		}
+		if err := netlink.RouteReplace(route); err != nil {
+			return fmt.Errorf("failed to add route %s via %s: %v", dst, s.network.InterfaceName, err)
+		}
+	}
 	return nil
 }
 
 func (s *NetworkService) SetupFirewall() error {
-	ipt, err := iptables.New()
+	if err := s.setupFamilyFirewall(iptables.ProtocolIPv4, s.network.Address, "net.ipv4.ip_forward=1"); err != nil {
+		return err
+	}
+
+	if s.network.Address6 != "" {
+		if err := s.setupFamilyFirewall(iptables.ProtocolIPv6, s.network.Address6, "net.ipv6.conf.all.forwarding=1"); err != nil {
+			return fmt.Errorf("ip6tables: %v", err)
+		}
+	}
+
+	log.Printf("Firewall rules (NAT) applied for %s", s.network.InterfaceName)
+	return nil
+}
+
+// Teardown xoá interface và firewall rules của mạng (khi mạng bị xoá)
+func (s *NetworkService) Teardown() error {
+	addresses := map[iptables.Protocol]string{iptables.ProtocolIPv4: s.network.Address}
+	if s.network.Address6 != "" {
+		addresses[iptables.ProtocolIPv6] = s.network.Address6
+	}
+
+	iface := s.network.InterfaceName
+	for proto, address := range addresses {
+		ipt, err := iptables.NewWithProtocol(proto)
+		if err != nil {
+			log.Printf("Warning: %v", err)
+			continue
+		}
+		for _, r := range interfaceRules(iface, address) {
+			_ = ipt.Delete(r.table, r.chain, r.spec...)
+		}
+		hook := aclHookRule(iface)
+		_ = ipt.Delete(hook.table, hook.chain, hook.spec...)
+		_ = ipt.ClearAndDeleteChain(hook.table, ACLChain(iface))
+	}
+
+	link, err := netlink.LinkByName(iface)
+	if err != nil {
+		return nil
+	}
+	if err := netlink.LinkDel(link); err != nil {
+		return fmt.Errorf("failed to delete interface %s: %v", iface, err)
+	}
+	log.Printf("Interface %s removed", iface)
+	return nil
+}
+
+// setupFamilyFirewall bật forwarding và áp dụng NAT/FORWARD rules cho một họ địa chỉ (iptables hoặc ip6tables)
+func (s *NetworkService) setupFamilyFirewall(proto iptables.Protocol, address, sysctl string) error {
+	ipt, err := iptables.NewWithProtocol(proto)
 	if err != nil {
 		return err
 	}
 
 	// Bật IP forwarding
-	cmd := exec.Command("sysctl", "-w", "net.ipv4.ip_forward=1")
+	cmd := exec.Command("sysctl", "-w", sysctl)
 	if err := cmd.Run(); err != nil {
-		log.Printf("Warning: failed to enable IP forwarding: %v", err)
+		log.Printf("Warning: failed to enable IP forwarding (%s): %v", sysctl, err)
 	}
 
-	// NAT Masquerade (ví dụ cho eth0, bạn nên detect interface mạng chính)
-	// Để đơn giản, ta apply cho toàn bộ traffic từ VPN pool
-	err = ipt.AppendUnique("nat", "POSTROUTING", "-s", s.cfg.Address, "-j", "MASQUERADE")
-	if err != nil {
-		return fmt.Errorf("failed to setup NAT: %v", err)
+	for _, r := range interfaceRules(s.network.InterfaceName, address) {
+		if err := ipt.AppendUnique(r.table, r.chain, r.spec...); err != nil {
+			return fmt.Errorf("failed to setup %s/%s rule: %v", r.table, r.chain, err)
+		}
 	}
 
-	log.Println("Firewall rules (NAT) applied")
 	return nil
 }
 
+// ApplyACL thay nội dung chain ACL của interface theo set rồi đảm bảo FORWARD chuyển traffic từ interface qua chain.
+// Chain được nạp nguyên khối bằng iptables-restore nên không có lúc nào rỗng hoặc mới dựng một nửa
+// (khi đó traffic sẽ rơi xuống rule ACCEPT của interface trong FORWARD).
+func (s *NetworkService) ApplyACL(set ACLRuleSet) error {
+	chain := ACLChain(s.network.InterfaceName)
+	hook := aclHookRule(s.network.InterfaceName)
+	for proto, rules := range set {
+		if err := restoreChain(proto, chain, rules); err != nil {
+			return fmt.Errorf("failed to load %s: %v", chain, err)
+		}
+
+		ipt, err := iptables.NewWithProtocol(proto)
+		if err != nil {
+			return err
+		}
+		exists, err := ipt.Exists(hook.table, hook.chain, hook.spec...)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			if err := ipt.Insert(hook.table, hook.chain, 1, hook.spec...); err != nil {
+				return fmt.Errorf("failed to hook %s into FORWARD: %v", chain, err)
+			}
+		}
+	}
+	return nil
+}
+
+// restoreChain thay toàn bộ rule của một chain trong bảng filter bằng một lần commit của iptables-restore.
+// Với --noflush chỉ chain được khai báo bị làm rỗng (và được tạo nếu chưa có), các chain khác giữ nguyên.
+func restoreChain(proto iptables.Protocol, chain string, rules []firewallRule) error {
+	cmdName := "iptables-restore"
+	if proto == iptables.ProtocolIPv6 {
+		cmdName = "ip6tables-restore"
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "*filter\n:%s - [0:0]\n", chain)
+	for _, r := range rules {
+		fmt.Fprintf(&b, "-A %s %s\n", r.chain, strings.Join(r.spec, " "))
+	}
+	b.WriteString("COMMIT\n")
+
+	cmd := exec.Command(cmdName, "--noflush")
+	cmd.Stdin = strings.NewReader(b.String())
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%s: %v: %s", cmdName, err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// firewallRule là một iptables rule do Wiretify quản lý
+type firewallRule struct {
+	table string
+	chain string
+	spec  []string
+}
+
+// interfaceRules là NAT/FORWARD rules của một interface cho một họ địa chỉ.
+// Dùng chung cho SetupFirewall, Teardown và export PostUp/PostDown.
+func interfaceRules(iface, address string) []firewallRule {
+	return []firewallRule{
+		// NAT Masquerade cho toàn bộ traffic từ VPN pool
+		{"nat", "POSTROUTING", []string{"-s", address, "-j", "MASQUERADE"}},
+		// Cho phép forward traffic vào/ra interface WireGuard (cần khi policy FORWARD mặc định là DROP)
+		{"filter", "FORWARD", []string{"-i", iface, "-j", "ACCEPT"}},
+		{"filter", "FORWARD", []string{"-o", iface, "-m", "state", "--state", "RELATED,ESTABLISHED", "-j", "ACCEPT"}},
+	}
+}
+
+// portForwardRules là các rule của một port forward: DNAT, MASQUERADE để traffic quay lại qua VPS, và FORWARD
+func portForwardRules(publicPort int, targetNode string, targetPort int, protocol string) []firewallRule {
+	return []firewallRule{
+		{"nat", "PREROUTING", []string{"-p", protocol, "--dport", fmt.Sprintf("%d", publicPort), "-j", "DNAT", "--to-destination", fmt.Sprintf("%s:%d", targetNode, targetPort)}},
+		{"nat", "POSTROUTING", []string{"-p", protocol, "-d", targetNode, "--dport", fmt.Sprintf("%d", targetPort), "-j", "MASQUERADE"}},
+		{"filter", "FORWARD", []string{"-p", protocol, "-d", targetNode, "--dport", fmt.Sprintf("%d", targetPort), "-j", "ACCEPT"}},
+	}
+}
+
 func (s *NetworkService) AddPortForward(publicPort int, targetNode string, targetPort int, protocol string) error {
 	ipt, err := iptables.New()
 	if err != nil {
 		return err
 	}
-	
-	ruleSpec := []string{"-p", protocol, "--dport", fmt.Sprintf("%d", publicPort), "-j", "DNAT", "--to-destination", fmt.Sprintf("%s:%d", targetNode, targetPort)}
-	err = ipt.AppendUnique("nat", "PREROUTING", ruleSpec...)
-	if err != nil {
-		return err
-	}
-
-	// Masquerade traffic to the destination to ensure it comes back through the VPS (SNAT)
-	masqRule := []string{"-p", protocol, "-d", targetNode, "--dport", fmt.Sprintf("%d", targetPort), "-j", "MASQUERADE"}
-	_ = ipt.AppendUnique("nat", "POSTROUTING", masqRule...)
 
-	// Allow forwarding
-	forwardRule := []string{"-p", protocol, "-d", targetNode, "--dport", fmt.Sprintf("%d", targetPort), "-j", "ACCEPT"}
-	err = ipt.AppendUnique("filter", "FORWARD", forwardRule...)
-	
-	if err == nil {
-		fmt.Printf("Network: Added Port Forward: Public %d/%s -> %s:%d\n", publicPort, protocol, targetNode, targetPort)
+	for _, r := range portForwardRules(publicPort, targetNode, targetPort, protocol) {
+		if err := ipt.AppendUnique(r.table, r.chain, r.spec...); err != nil {
+			return err
+		}
 	}
-	return err
+
+	fmt.Printf("Network: Added Port Forward: Public %d/%s -> %s:%d\n", publicPort, protocol, targetNode, targetPort)
+	return nil
 }
 
 func (s *NetworkService) RemovePortForward(publicPort int, targetNode string, targetPort int, protocol string) error {
@@ -114,16 +306,30 @@ func (s *NetworkService) RemovePortForward(publicPort int, targetNode string, ta
 	if err != nil {
 		return err
 	}
-	
-	fmt.Printf("Network: Removing Port Forward: Public %d/%s -> %s:%d\n", publicPort, protocol, targetNode, targetPort)
-	
-	ruleSpec := []string{"-p", protocol, "--dport", fmt.Sprintf("%d", publicPort), "-j", "DNAT", "--to-destination", fmt.Sprintf("%s:%d", targetNode, targetPort)}
-	_ = ipt.Delete("nat", "PREROUTING", ruleSpec...)
 
-	masqRule := []string{"-p", protocol, "-d", targetNode, "--dport", fmt.Sprintf("%d", targetPort), "-j", "MASQUERADE"}
-	_ = ipt.Delete("nat", "POSTROUTING", masqRule...)
+	fmt.Printf("Network: Removing Port Forward: Public %d/%s -> %s:%d\n", publicPort, protocol, targetNode, targetPort)
 
-	forwardRule := []string{"-p", protocol, "-d", targetNode, "--dport", fmt.Sprintf("%d", targetPort), "-j", "ACCEPT"}
-	_ = ipt.Delete("filter", "FORWARD", forwardRule...)
+	for _, r := range portForwardRules(publicPort, targetNode, targetPort, protocol) {
+		_ = ipt.Delete(r.table, r.chain, r.spec...)
+	}
 	return nil
 }
+
+// SuspendPortForwards gỡ iptables rules của các port forward nhưng giữ nguyên bản ghi trong DB
+// (dùng khi peer đích bị disable)
+func (s *NetworkService) SuspendPortForwards(pfs []models.PortForward) {
+	for _, pf := range pfs {
+		if err := s.RemovePortForward(pf.PublicPort, pf.TargetNode, pf.TargetPort, pf.Protocol); err != nil {
+			log.Printf("Warning: failed to suspend port forward %d -> %s:%d: %v", pf.PublicPort, pf.TargetNode, pf.TargetPort, err)
+		}
+	}
+}
+
+// RestorePortForwards áp dụng lại iptables rules cho các port forward đã lưu
+func (s *NetworkService) RestorePortForwards(pfs []models.PortForward) {
+	for _, pf := range pfs {
+		if err := s.AddPortForward(pf.PublicPort, pf.TargetNode, pf.TargetPort, pf.Protocol); err != nil {
+			log.Printf("Warning: Failed to restore port forward %d -> %s:%d : %v", pf.PublicPort, pf.TargetNode, pf.TargetPort, err)
+		}
+	}
+}
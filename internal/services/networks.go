@@ -0,0 +1,310 @@
+package services
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"wiretify/internal/config"
+	"wiretify/internal/database"
+	"wiretify/internal/models"
+	"wiretify/internal/secrets"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+	"gorm.io/gorm"
+)
+
+// WGNetwork gom các service của một mạng WireGuard.
+// WG là nil khi không khởi tạo được wgctrl (vd. không có quyền root).
+type WGNetwork struct {
+	models.Network
+	WG  *WGService
+	Net *NetworkService
+}
+
+// NetworkManager giữ các mạng đang chạy, theo ID
+type NetworkManager struct {
+	cfg *config.Config
+
+	mu        sync.RWMutex
+	networks  map[uint]*WGNetwork
+	defaultID uint
+}
+
+func NewNetworkManager(cfg *config.Config) *NetworkManager {
+	return &NetworkManager{cfg: cfg, networks: make(map[uint]*WGNetwork)}
+}
+
+// Load tạo mạng mặc định từ config nếu DB chưa có mạng nào (và gán các bản ghi cũ vào mạng đó),
+// sau đó nạp mọi mạng trong DB
+func (m *NetworkManager) Load() error {
+	if err := m.ensureDefaultNetwork(); err != nil {
+		return err
+	}
+
+	var networks []models.Network
+	if err := database.DB.Order("id").Find(&networks).Error; err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i, n := range networks {
+		if i == 0 {
+			m.defaultID = n.ID
+		}
+		m.networks[n.ID] = m.newWGNetwork(n)
+	}
+	return nil
+}
+
+func (m *NetworkManager) newWGNetwork(n models.Network) *WGNetwork {
+	wn := &WGNetwork{Network: n, Net: NewNetworkService(n)}
+	wgSvc, err := NewWGService(m.cfg, n)
+	if err != nil {
+		log.Printf("Warning: WireGuard controller failed to init for %s: %v", n.InterfaceName, err)
+	} else {
+		wn.WG = wgSvc
+	}
+	return wn
+}
+
+// ensureDefaultNetwork chuyển cấu hình một interface cũ (WG_INTERFACE, WG_ADDRESS, WG_PORT...) thành mạng đầu tiên
+func (m *NetworkManager) ensureDefaultNetwork() error {
+	var count int64
+	if err := database.DB.Model(&models.Network{}).Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return nil
+	}
+
+	key, err := m.legacyServerKey()
+	if err != nil {
+		return err
+	}
+
+	network := models.Network{
+		Name:          "default",
+		InterfaceName: m.cfg.InterfaceName,
+		Address:       m.cfg.Address,
+		Address6:      m.cfg.Address6,
+		ListenPort:    m.cfg.Port,
+		PrivateKey:    key.String(),
+	}
+	return database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&network).Error; err != nil {
+			return err
+		}
+		for _, model := range []interface{}{&models.Peer{}, &models.PortForward{}, &models.Endpoint{}} {
+			if err := tx.Unscoped().Model(model).Where("network_id = 0 OR network_id IS NULL").Update("network_id", network.ID).Error; err != nil {
+				return err
+			}
+		}
+		log.Printf("Created network %s on %s", network.Name, network.InterfaceName)
+		return tx.Where(&models.Setting{Key: models.SettingServerPrivateKey}).Delete(&models.Setting{}).Error
+	})
+}
+
+// legacyServerKey lấy server key từ key store cũ (settings), WG_PRIVATE_KEY, hoặc sinh mới
+func (m *NetworkManager) legacyServerKey() (wgtypes.Key, error) {
+	var setting models.Setting
+	res := database.DB.Where(&models.Setting{Key: models.SettingServerPrivateKey}).Limit(1).Find(&setting)
+	if res.Error != nil {
+		return wgtypes.Key{}, res.Error
+	}
+	if res.RowsAffected == 1 {
+		plaintext, err := secrets.Open(setting.Value)
+		if err != nil {
+			return wgtypes.Key{}, fmt.Errorf("failed to decrypt server key: %v", err)
+		}
+		return wgtypes.ParseKey(plaintext)
+	}
+
+	if m.cfg.PrivateKey != "" {
+		key, err := wgtypes.ParseKey(m.cfg.PrivateKey)
+		if err != nil {
+			return wgtypes.Key{}, fmt.Errorf("invalid WG_PRIVATE_KEY: %v", err)
+		}
+		log.Printf("Moved WG_PRIVATE_KEY into the encrypted key store, it can now be removed from .env")
+		return key, nil
+	}
+
+	log.Printf("Generated initial Server Private Key.")
+	return wgtypes.GeneratePrivateKey()
+}
+
+// Start dựng interface, firewall và đồng bộ peer cho một mạng
+func (m *NetworkManager) Start(wn *WGNetwork) {
+	if err := wn.Net.SetupInterface(); err != nil {
+		log.Printf("Warning: Interface setup failed: %v (May require root/NET_ADMIN)", err)
+	}
+	if err := wn.Net.SetupFirewall(); err != nil {
+		log.Printf("Warning: Firewall setup failed: %v", err)
+	}
+	if err := applyACL(database.DB, wn); err != nil {
+		log.Printf("Warning: Access control setup failed on %s: %v", wn.InterfaceName, err)
+	}
+	if wn.WG == nil {
+		return
+	}
+
+	var peers []models.Peer
+	database.DB.Where("network_id = ?", wn.ID).Find(&peers)
+	if _, err := wn.WG.SyncPeers(peers); err != nil {
+		log.Printf("Warning: Failed to sync initial peers to %s: %v", wn.InterfaceName, err)
+	}
+	if err := wn.Net.SyncRoutes(peers); err != nil {
+		log.Printf("Warning: Failed to sync routed subnets on %s: %v", wn.InterfaceName, err)
+	}
+}
+
+// Add lưu một mạng mới (sinh server key) và khởi động nó
+func (m *NetworkManager) Add(network *models.Network) (*WGNetwork, error) {
+	key, err := wgtypes.GeneratePrivateKey()
+	if err != nil {
+		return nil, err
+	}
+	network.PrivateKey = key.String()
+	if err := database.DB.Create(network).Error; err != nil {
+		return nil, err
+	}
+
+	wn := m.newWGNetwork(*network)
+	m.mu.Lock()
+	m.networks[network.ID] = wn
+	m.mu.Unlock()
+
+	m.Start(wn)
+	return wn, nil
+}
+
+// remove gỡ interface và firewall rules của mạng rồi xoá nó khỏi DB; chỉ được gọi qua PeerService.RemoveNetwork.
+// Mạng mặc định và mạng còn peer, port forward hoặc endpoint không xoá được.
+func (m *NetworkManager) remove(id uint) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	wn, ok := m.networks[id]
+	if !ok {
+		return fmt.Errorf("network not found")
+	}
+	if id == m.defaultID {
+		return fmt.Errorf("the default network cannot be deleted")
+	}
+
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		// Port forward và endpoint có rule/route riêng trên server, phải được xoá trước
+		for _, dep := range []struct {
+			model interface{}
+			label string
+		}{
+			{&models.Peer{}, "peers"},
+			{&models.PortForward{}, "port forwards"},
+			{&models.Endpoint{}, "endpoints"},
+		} {
+			var count int64
+			if err := tx.Model(dep.model).Where("network_id = ?", id).Count(&count).Error; err != nil {
+				return err
+			}
+			if count > 0 {
+				return fmt.Errorf("network still has %d %s", count, dep.label)
+			}
+		}
+
+		if err := tx.Where("network_id = ?", id).Delete(&models.Reservation{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("network_id = ?", id).Delete(&models.ACLRule{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&models.Network{}, id).Error
+	})
+	if err != nil {
+		return err
+	}
+	if err := wn.Net.Teardown(); err != nil {
+		log.Printf("Warning: %v", err)
+	}
+	if wn.WG != nil {
+		wn.WG.Close()
+	}
+	delete(m.networks, id)
+	return nil
+}
+
+// Get trả về mạng theo ID, nil nếu không có
+func (m *NetworkManager) Get(id uint) *WGNetwork {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.networks[id]
+}
+
+// Default là mạng được dùng khi request không chọn mạng
+func (m *NetworkManager) Default() *WGNetwork {
+	return m.Get(m.defaultID)
+}
+
+// ForPeer trả về mạng của peer (mạng mặc định nếu không tìm thấy)
+func (m *NetworkManager) ForPeer(peer models.Peer) *WGNetwork {
+	if wn := m.Get(peer.NetworkID); wn != nil {
+		return wn
+	}
+	return m.Default()
+}
+
+// All trả về mọi mạng, theo thứ tự ID
+func (m *NetworkManager) All() []*WGNetwork {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	all := make([]*WGNetwork, 0, len(m.networks))
+	for _, wn := range m.networks {
+		all = append(all, wn)
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].ID < all[j].ID })
+	return all
+}
+
+// Pools trả về subnet (v4, v6) của mọi mạng
+func (m *NetworkManager) Pools() []string {
+	var pools []string
+	for _, wn := range m.All() {
+		pools = append(pools, wn.Address)
+		if wn.Address6 != "" {
+			pools = append(pools, wn.Address6)
+		}
+	}
+	return pools
+}
+
+// DevicePeers gộp trạng thái kernel của peer trên mọi interface (theo public key)
+func (m *NetworkManager) DevicePeers() (map[string]wgtypes.Peer, error) {
+	merged := make(map[string]wgtypes.Peer)
+	var lastErr error
+	for _, wn := range m.All() {
+		if wn.WG == nil {
+			continue
+		}
+		peers, err := wn.WG.GetDevicePeers()
+		if err != nil {
+			lastErr = fmt.Errorf("%s: %v", wn.InterfaceName, err)
+			continue
+		}
+		for key, p := range peers {
+			merged[key] = p
+		}
+	}
+	if len(merged) == 0 && lastErr != nil {
+		return nil, lastErr
+	}
+	return merged, nil
+}
+
+// Close đóng wgctrl client của mọi mạng
+func (m *NetworkManager) Close() {
+	for _, wn := range m.All() {
+		if wn.WG != nil {
+			wn.WG.Close()
+		}
+	}
+}
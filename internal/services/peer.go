@@ -0,0 +1,423 @@
+package services
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+	"wiretify/internal/database"
+	"wiretify/internal/models"
+
+	"gorm.io/gorm"
+)
+
+// PeerService gom các thao tác vòng đời peer cần phối hợp giữa DB, WireGuard và iptables,
+// dùng chung cho API handlers và các tác vụ chạy nền.
+// Mọi thay đổi peer được tuần tự hoá bằng mu để hai request không cấp trùng IP hay ghi đè nhau.
+type PeerService struct {
+	networks *NetworkManager
+	mu       sync.Mutex
+}
+
+func NewPeerService(networks *NetworkManager) *PeerService {
+	return &PeerService{networks: networks}
+}
+
+// ApplyError cho biết thay đổi không áp dụng được vào kernel và DB đã được rollback
+type ApplyError struct {
+	Interface string
+	Err       error
+}
+
+func (e *ApplyError) Error() string {
+	return fmt.Sprintf("failed to apply changes to %s, changes were rolled back: %v", e.Interface, e.Err)
+}
+
+func (e *ApplyError) Unwrap() error { return e.Err }
+
+// peerChange là một thay đổi peer đang chạy trong transaction
+type peerChange struct {
+	tx       *gorm.DB
+	networks map[uint]bool
+	commit   []func() // Thao tác iptables chạy sau khi commit
+}
+
+// touch đánh dấu mạng cần áp dụng vào kernel trước khi commit
+func (ch *peerChange) touch(networkID uint) {
+	ch.networks[networkID] = true
+}
+
+func (ch *peerChange) afterCommit(fn func()) {
+	ch.commit = append(ch.commit, fn)
+}
+
+// change chạy fn trong một transaction (giữ mu), rồi áp trạng thái peer đọc từ transaction
+// vào kernel của các mạng bị ảnh hưởng. Nếu kernel lỗi thì rollback DB và sync lại kernel
+// theo DB để gỡ phần đã áp dụng dở.
+func (s *PeerService) change(fn func(ch *peerChange) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ch := &peerChange{networks: make(map[uint]bool)}
+	applied := false
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		ch.tx = tx
+		if err := fn(ch); err != nil {
+			return err
+		}
+		applied = true
+		for id := range ch.networks {
+			wn := s.networks.Get(id)
+			if wn == nil {
+				return fmt.Errorf("network %d not found", id)
+			}
+			if _, err := s.syncNetwork(tx, wn); err != nil {
+				return &ApplyError{Interface: wn.InterfaceName, Err: err}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		if applied {
+			for id := range ch.networks {
+				if wn := s.networks.Get(id); wn != nil {
+					s.syncNetwork(database.DB, wn)
+				}
+			}
+		}
+		return err
+	}
+
+	for _, fn := range ch.commit {
+		fn()
+	}
+	// Địa chỉ/group của peer thay đổi thì ACL cũng phải biên dịch lại
+	for id := range ch.networks {
+		if wn := s.networks.Get(id); wn != nil {
+			s.syncACL(wn)
+		}
+	}
+	return nil
+}
+
+// Transaction chạy fn trong transaction với các thay đổi peer khác bị chặn, rồi áp mạng networkID
+// vào kernel trước khi commit. Trả về *ApplyError nếu kernel lỗi (DB đã được rollback).
+func (s *PeerService) Transaction(networkID uint, fn func(tx *gorm.DB) error) error {
+	return s.change(func(ch *peerChange) error {
+		ch.touch(networkID)
+		return fn(ch.tx)
+	})
+}
+
+// RemoveNetwork xoá mạng khi không có thay đổi peer nào đang chạy,
+// để không peer nào được tạo hoặc chuyển vào mạng trong lúc nó bị gỡ
+func (s *PeerService) RemoveNetwork(id uint) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.networks.remove(id)
+}
+
+// Exclusive chạy fn trong transaction với các thay đổi peer và ACL khác bị chặn, không áp dụng gì vào kernel
+func (s *PeerService) Exclusive(fn func(tx *gorm.DB) error) error {
+	return s.change(func(ch *peerChange) error {
+		return fn(ch.tx)
+	})
+}
+
+// Sync đồng bộ mọi mạng (peer và chain ACL) với kernel; trả về tổng hợp thay đổi
+func (s *PeerService) Sync() (*SyncResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	total := &SyncResult{Added: []string{}, Removed: []string{}, Updated: []string{}}
+	var firstErr error
+	for _, wn := range s.networks.All() {
+		s.syncACL(wn)
+		result, err := s.syncNetwork(database.DB, wn)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		total.Added = append(total.Added, result.Added...)
+		total.Removed = append(total.Removed, result.Removed...)
+		total.Updated = append(total.Updated, result.Updated...)
+	}
+	return total, firstErr
+}
+
+// SyncNetwork nạp lại peer của một mạng từ DB, đối chiếu với kernel và cập nhật route của các subnet LAN
+func (s *PeerService) SyncNetwork(networkID uint) (*SyncResult, error) {
+	wn := s.networks.Get(networkID)
+	if wn == nil {
+		return nil, fmt.Errorf("network %d not found", networkID)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.syncACL(wn)
+	return s.syncNetwork(database.DB, wn)
+}
+
+// syncNetwork áp peer của mạng đọc từ db (DB hoặc transaction đang chạy) vào kernel
+func (s *PeerService) syncNetwork(db *gorm.DB, wn *WGNetwork) (*SyncResult, error) {
+	if wn.WG == nil {
+		return nil, fmt.Errorf("WireGuard controller for %s is not available", wn.InterfaceName)
+	}
+
+	var peers []models.Peer
+	if err := db.Where("network_id = ?", wn.ID).Find(&peers).Error; err != nil {
+		return nil, err
+	}
+
+	result, err := wn.WG.SyncPeers(peers)
+	if err != nil {
+		log.Printf("Warning: failed to sync peers to %s: %v", wn.InterfaceName, err)
+		return nil, err
+	}
+
+	if err := wn.Net.SyncRoutes(peers); err != nil {
+		log.Printf("Warning: failed to sync routed subnets on %s: %v", wn.InterfaceName, err)
+		return nil, err
+	}
+	return result, nil
+}
+
+// PortForwards trả về các port forward trỏ tới IP của peer
+func (s *PeerService) PortForwards(peer models.Peer) []models.PortForward {
+	return portForwardsOf(database.DB, peer)
+}
+
+func portForwardsOf(db *gorm.DB, peer models.Peer) []models.PortForward {
+	var pfs []models.PortForward
+	db.Where("target_node = ?", peer.IP()).Find(&pfs)
+	return pfs
+}
+
+// Disable tắt peer, gỡ port forward của nó khỏi iptables và áp dụng vào kernel.
+// reason được lưu lại để biết peer bị tắt tự động (vd. hết hạn) hay thủ công.
+func (s *PeerService) Disable(peer *models.Peer, reason string) error {
+	return s.change(func(ch *peerChange) error {
+		return s.setEnabled(ch, peer, false, reason)
+	})
+}
+
+// Enable bật lại peer, khôi phục port forward và áp dụng vào kernel
+func (s *PeerService) Enable(peer *models.Peer) error {
+	return s.change(func(ch *peerChange) error {
+		return s.setEnabled(ch, peer, true, "")
+	})
+}
+
+// SetEnabled bật/tắt các peer do load chọn trong cùng transaction; mỗi mạng chỉ sync với kernel một lần.
+// Trả về các peer đã được bật/tắt.
+func (s *PeerService) SetEnabled(load func(tx *gorm.DB) ([]models.Peer, error), enabled bool, reason string) ([]models.Peer, error) {
+	var peers []models.Peer
+	err := s.change(func(ch *peerChange) error {
+		var err error
+		if peers, err = load(ch.tx); err != nil {
+			return err
+		}
+		for i := range peers {
+			if err := s.setEnabled(ch, &peers[i], enabled, reason); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return peers, err
+}
+
+func (s *PeerService) setEnabled(ch *peerChange, peer *models.Peer, enabled bool, reason string) error {
+	wasEnabled := peer.Enabled
+	if err := ch.tx.Model(peer).Updates(map[string]interface{}{"enabled": enabled, "disabled_reason": reason}).Error; err != nil {
+		return err
+	}
+	peer.Enabled, peer.DisabledReason = enabled, reason
+	ch.touch(peer.NetworkID)
+	if peer.Mesh && wasEnabled != enabled {
+		if err := MarkMeshOutdated(ch.tx, peer.NetworkID, peer.ID); err != nil {
+			return err
+		}
+	}
+
+	pfs := portForwardsOf(ch.tx, *peer)
+	net := s.networks.ForPeer(*peer).Net
+	switch {
+	case wasEnabled && !enabled:
+		ch.afterCommit(func() { net.SuspendPortForwards(pfs) })
+	case !wasEnabled && enabled:
+		ch.afterCommit(func() { net.RestorePortForwards(pfs) })
+	}
+	return nil
+}
+
+// Update đọc lại peer trong transaction, cho apply sửa nó rồi lưu mọi cột (trừ các cột usage do monitor cập nhật).
+// Port forward đi theo IP mới của peer và được gỡ/áp lại theo trạng thái enabled sau khi commit.
+func (s *PeerService) Update(peerID uint, apply func(tx *gorm.DB, peer *models.Peer) error) (*models.Peer, error) {
+	var peer models.Peer
+	err := s.change(func(ch *peerChange) error {
+		if err := ch.tx.First(&peer, peerID).Error; err != nil {
+			return err
+		}
+		old := peer
+		oldIP, wasEnabled := peer.IP(), peer.Enabled
+
+		if err := apply(ch.tx, &peer); err != nil {
+			return err
+		}
+		if meshChanged(old, peer) {
+			if err := MarkMeshOutdated(ch.tx, peer.NetworkID, peer.ID); err != nil {
+				return err
+			}
+		}
+		// Select("*") để lưu cả các giá trị zero (vd. enabled=false)
+		if err := ch.tx.Select("*").Omit("usage_rx_bytes", "usage_tx_bytes", "last_kernel_rx", "last_kernel_tx").Save(&peer).Error; err != nil {
+			return err
+		}
+		ch.touch(peer.NetworkID)
+
+		// Port forward gắn với peer qua IP: gỡ rule cũ, đổi target nếu IP thay đổi, rồi áp dụng lại nếu peer đang bật
+		var pfs []models.PortForward
+		if err := ch.tx.Where("target_node = ?", oldIP).Find(&pfs).Error; err != nil {
+			return err
+		}
+		suspend := append([]models.PortForward(nil), pfs...)
+		if newIP := peer.IP(); newIP != oldIP {
+			if err := ch.tx.Model(&models.PortForward{}).Where("target_node = ?", oldIP).Update("target_node", newIP).Error; err != nil {
+				return err
+			}
+			for i := range pfs {
+				pfs[i].TargetNode = newIP
+			}
+		}
+
+		net, enabled := s.networks.ForPeer(peer).Net, peer.Enabled
+		ch.afterCommit(func() {
+			if wasEnabled {
+				net.SuspendPortForwards(suspend)
+			}
+			if enabled {
+				net.RestorePortForwards(pfs)
+			}
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &peer, nil
+}
+
+// Extend đọc lại peer trong transaction, đặt hạn mới do expiry tính từ hạn hiện tại (nil là không hết hạn)
+// và bật lại peer nếu nó đang bị tắt do hết hạn mà hạn mới nằm trong tương lai.
+func (s *PeerService) Extend(peerID uint, expiry func(current *time.Time) (*time.Time, error)) (*models.Peer, error) {
+	var peer models.Peer
+	err := s.change(func(ch *peerChange) error {
+		if err := ch.tx.First(&peer, peerID).Error; err != nil {
+			return err
+		}
+		expiresAt, err := expiry(peer.ExpiresAt)
+		if err != nil {
+			return err
+		}
+		if err := ch.tx.Model(&peer).Update("expires_at", expiresAt).Error; err != nil {
+			return err
+		}
+		peer.ExpiresAt = expiresAt
+
+		if !peer.Enabled && peer.DisabledReason == models.DisabledReasonExpired && (expiresAt == nil || expiresAt.After(time.Now())) {
+			return s.setEnabled(ch, &peer, true, "")
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &peer, nil
+}
+
+// Delete xoá peer cùng các port forward và share link của nó, rồi gỡ peer khỏi device.
+// purge = true sẽ xoá hẳn bản ghi (không soft-delete) để tên và key có thể dùng lại.
+func (s *PeerService) Delete(peer models.Peer, purge bool) error {
+	return s.change(func(ch *peerChange) error {
+		return s.deletePeer(ch, peer, purge)
+	})
+}
+
+// DeleteMany xoá các peer do load chọn trong cùng transaction; mỗi mạng chỉ sync với kernel một lần.
+// Trả về các peer đã bị xoá.
+func (s *PeerService) DeleteMany(load func(tx *gorm.DB) ([]models.Peer, error)) ([]models.Peer, error) {
+	var peers []models.Peer
+	err := s.change(func(ch *peerChange) error {
+		var err error
+		if peers, err = load(ch.tx); err != nil {
+			return err
+		}
+		for _, p := range peers {
+			if err := s.deletePeer(ch, p, false); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return peers, err
+}
+
+func (s *PeerService) deletePeer(ch *peerChange, peer models.Peer, purge bool) error {
+	db := ch.tx
+	if purge {
+		db = db.Unscoped()
+	}
+
+	// 1. Delete all port forwards for this peer, iptables rules are removed after commit
+	pfs := portForwardsOf(ch.tx, peer)
+	for _, pf := range pfs {
+		if err := db.Delete(&pf).Error; err != nil {
+			return err
+		}
+	}
+	net := s.networks.ForPeer(peer).Net
+	ch.afterCommit(func() {
+		for _, pf := range pfs {
+			if err := net.RemovePortForward(pf.PublicPort, pf.TargetNode, pf.TargetPort, pf.Protocol); err != nil {
+				log.Printf("Warning: failed to remove iptables rules for port forward %d during peer deletion: %v", pf.PublicPort, err)
+			}
+		}
+	})
+
+	if err := ch.tx.Where("peer_id = ?", peer.ID).Delete(&models.ShareLink{}).Error; err != nil {
+		return err
+	}
+	if purge {
+		if err := ch.tx.Where("peer_id = ?", peer.ID).Delete(&models.PeerSample{}).Error; err != nil {
+			return err
+		}
+	}
+
+	if peer.Mesh {
+		if err := MarkMeshOutdated(ch.tx, peer.NetworkID, peer.ID); err != nil {
+			return err
+		}
+	}
+
+	// Rule ACL trỏ tới peer không còn ý nghĩa
+	peerID := strconv.FormatUint(uint64(peer.ID), 10)
+	if err := ch.tx.Where("(source_type = ? AND source = ?) OR (dest_type = ? AND dest = ?)", models.ACLTargetPeer, peerID, models.ACLTargetPeer, peerID).Delete(&models.ACLRule{}).Error; err != nil {
+		return err
+	}
+
+	// 2. Delete peer from DB, the kernel is synced before commit
+	ch.touch(peer.NetworkID)
+	return db.Delete(&peer).Error
+}
+
+// RecordPeerEvent lưu một sự kiện của peer vào DB
+func RecordPeerEvent(peerID uint, eventType, message string) {
+	event := models.PeerEvent{PeerID: peerID, Type: eventType, Message: message, CreatedAt: time.Now().UTC()}
+	if err := database.DB.Create(&event).Error; err != nil {
+		log.Printf("Warning: failed to record %s event for peer %d: %v", eventType, peerID, err)
+	}
+}
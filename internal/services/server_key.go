@@ -0,0 +1,60 @@
+package services
+
+import (
+	"fmt"
+	"log"
+	"wiretify/internal/database"
+	"wiretify/internal/models"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+	"gorm.io/gorm"
+)
+
+// privateKey đọc server key của mạng từ DB (được mã hoá bằng master key)
+func (s *WGService) privateKey() (wgtypes.Key, error) {
+	return loadServerKey(s.network)
+}
+
+func loadServerKey(n models.Network) (wgtypes.Key, error) {
+	var network models.Network
+	if err := database.DB.Select("id", "private_key").First(&network, n.ID).Error; err != nil {
+		return wgtypes.Key{}, err
+	}
+	if network.PrivateKey == "" {
+		return wgtypes.Key{}, fmt.Errorf("network %s has no server key", n.Name)
+	}
+	return wgtypes.ParseKey(network.PrivateKey)
+}
+
+// storeServerKey lưu server key của mạng; update qua struct để serializer mã hoá key
+func storeServerKey(db *gorm.DB, networkID uint, key wgtypes.Key) error {
+	network := models.Network{ID: networkID, PrivateKey: key.String()}
+	return db.Model(&network).Select("private_key").Updates(&network).Error
+}
+
+// RotateServerKey sinh private key mới cho mạng, đánh dấu mọi peer của mạng cần tải lại config
+// và áp key mới lên interface. Trả về public key mới.
+func (s *WGService) RotateServerKey() (string, error) {
+	key, err := wgtypes.GeneratePrivateKey()
+	if err != nil {
+		return "", err
+	}
+
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := storeServerKey(tx, s.network.ID, key); err != nil {
+			return err
+		}
+		return tx.Model(&models.Peer{}).Where("network_id = ?", s.network.ID).Update("config_outdated", true).Error
+	})
+	if err != nil {
+		return "", err
+	}
+
+	pubKey := key.PublicKey().String()
+	log.Printf("Server key of network %s rotated, new public key %s", s.network.Name, pubKey)
+
+	if err := s.client.ConfigureDevice(s.network.InterfaceName, wgtypes.Config{PrivateKey: &key}); err != nil {
+		return pubKey, fmt.Errorf("new key stored but failed to apply it to %s: %v", s.network.InterfaceName, err)
+	}
+	return pubKey, nil
+}
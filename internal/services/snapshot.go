@@ -0,0 +1,206 @@
+package services
+
+import (
+	"fmt"
+	"time"
+	"wiretify/internal/database"
+	"wiretify/internal/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// SnapshotVersion tăng mỗi khi định dạng snapshot thay đổi không tương thích
+const SnapshotVersion = 1
+
+// Snapshot là bản xuất toàn bộ cấu hình để backup hoặc chuyển sang máy khác.
+// Secret (server key, private key, PSK) được xuất ở dạng plaintext để khôi phục được với master key khác.
+// Lịch sử (peer events, traffic samples) và share link không nằm trong snapshot.
+type Snapshot struct {
+	Version      int                  `json:"version"`
+	CreatedAt    time.Time            `json:"created_at"`
+	Networks     []SnapshotNetwork    `json:"networks"`
+	Peers        []SnapshotPeer       `json:"peers"`
+	PortForwards []models.PortForward `json:"port_forwards"`
+	Domains      []models.Domain      `json:"domains"`
+	Endpoints    []SnapshotEndpoint   `json:"endpoints"`
+	Profiles     []models.Profile     `json:"profiles"`
+	Groups       []models.Group       `json:"groups"`
+	Reservations []models.Reservation `json:"reservations"`
+	ACLRules     []models.ACLRule     `json:"acl_rules"`
+	Settings     []SnapshotSetting    `json:"settings"`
+}
+
+// SnapshotNetwork thêm server key và chế độ ACL (bị ẩn trong API) vào mạng
+type SnapshotNetwork struct {
+	models.Network
+	PrivateKey string `json:"private_key"`
+	ACLMode    string `json:"acl_mode,omitempty"`
+}
+
+// SnapshotPeer thêm private key và PSK (bị ẩn trong API) vào peer
+type SnapshotPeer struct {
+	models.Peer
+	PrivateKey   string `json:"private_key,omitempty"`
+	PresharedKey string `json:"preshared_key,omitempty"`
+}
+
+// SnapshotEndpoint là endpoint không kèm peer/domain lồng nhau
+type SnapshotEndpoint struct {
+	ID        uint      `json:"id"`
+	NetworkID uint      `json:"network_id"`
+	PeerID    uint      `json:"peer_id"`
+	DomainID  uint      `json:"domain_id"`
+	Subdomain string    `json:"subdomain"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+type SnapshotSetting struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+// ExportSnapshot đọc toàn bộ cấu hình hiện tại (không gồm bản ghi đã soft-delete)
+func ExportSnapshot() (*Snapshot, error) {
+	db := database.DB
+	snap := &Snapshot{Version: SnapshotVersion, CreatedAt: time.Now().UTC()}
+
+	var networks []models.Network
+	var peers []models.Peer
+	var endpoints []models.Endpoint
+	var settings []models.Setting
+	for _, q := range []struct {
+		dest  interface{}
+		label string
+	}{
+		{&networks, "networks"},
+		{&peers, "peers"},
+		{&snap.PortForwards, "port forwards"},
+		{&snap.Domains, "domains"},
+		{&endpoints, "endpoints"},
+		{&snap.Profiles, "profiles"},
+		{&snap.Groups, "groups"},
+		{&snap.Reservations, "reservations"},
+		{&snap.ACLRules, "access control rules"},
+		{&settings, "settings"},
+	} {
+		if err := db.Find(q.dest).Error; err != nil {
+			return nil, fmt.Errorf("failed to read %s: %v", q.label, err)
+		}
+	}
+
+	snap.Networks = make([]SnapshotNetwork, len(networks))
+	for i, n := range networks {
+		snap.Networks[i] = SnapshotNetwork{Network: n, PrivateKey: n.PrivateKey, ACLMode: n.ACLMode}
+	}
+	snap.Peers = make([]SnapshotPeer, len(peers))
+	for i, p := range peers {
+		snap.Peers[i] = SnapshotPeer{Peer: p, PrivateKey: p.PrivateKey, PresharedKey: p.PresharedKey}
+	}
+	snap.Endpoints = make([]SnapshotEndpoint, len(endpoints))
+	for i, ep := range endpoints {
+		snap.Endpoints[i] = SnapshotEndpoint{ID: ep.ID, NetworkID: ep.NetworkID, PeerID: ep.PeerID, DomainID: ep.DomainID, Subdomain: ep.Subdomain, CreatedAt: ep.CreatedAt, UpdatedAt: ep.UpdatedAt}
+	}
+	snap.Settings = make([]SnapshotSetting, len(settings))
+	for i, s := range settings {
+		snap.Settings[i] = SnapshotSetting{Key: s.Key, Value: s.Value}
+	}
+	return snap, nil
+}
+
+// RestoreSnapshot thay toàn bộ cấu hình trong DB bằng snapshot, trong một transaction.
+// Giữ nguyên ID để các liên kết (peer ↔ network, endpoint ↔ domain...) còn đúng.
+// Service phải được khởi động lại sau đó để dựng lại interface và firewall.
+func RestoreSnapshot(snap *Snapshot) error {
+	if snap.Version < 1 || snap.Version > SnapshotVersion {
+		return fmt.Errorf("unsupported snapshot version %d (this build supports up to %d)", snap.Version, SnapshotVersion)
+	}
+	if len(snap.Networks) == 0 {
+		return fmt.Errorf("snapshot contains no networks")
+	}
+
+	return database.DB.Transaction(func(tx *gorm.DB) error {
+		// Xoá cả lịch sử vì ID peer cũ có thể trỏ sang peer khác sau khi khôi phục
+		for _, model := range []interface{}{
+			&models.PeerSample{}, &models.PeerEvent{}, &models.ShareLink{}, &models.Endpoint{}, &models.PortForward{},
+			&models.ACLRule{}, &models.Peer{}, &models.Domain{}, &models.Profile{}, &models.Setting{}, &models.Reservation{}, &models.Group{}, &models.Network{},
+		} {
+			if err := tx.Session(&gorm.Session{AllowGlobalUpdate: true}).Unscoped().Delete(model).Error; err != nil {
+				return err
+			}
+		}
+
+		create := func(label string, value interface{}) error {
+			if err := tx.Omit(clause.Associations).Create(value).Error; err != nil {
+				return fmt.Errorf("failed to restore %s: %v", label, err)
+			}
+			return nil
+		}
+
+		for _, n := range snap.Networks {
+			network := n.Network
+			network.PrivateKey = n.PrivateKey
+			network.ACLMode = n.ACLMode
+			if err := create("network "+network.Name, &network); err != nil {
+				return err
+			}
+		}
+		for _, p := range snap.Profiles {
+			if err := create("profile "+p.Name, &p); err != nil {
+				return err
+			}
+		}
+		for _, g := range snap.Groups {
+			if err := create("group "+g.Name, &g); err != nil {
+				return err
+			}
+		}
+		for _, d := range snap.Domains {
+			if err := create("domain "+d.Name, &d); err != nil {
+				return err
+			}
+		}
+		for _, sp := range snap.Peers {
+			peer := sp.Peer
+			peer.PrivateKey = sp.PrivateKey
+			peer.PresharedKey = sp.PresharedKey
+			if err := create("peer "+peer.Name, &peer); err != nil {
+				return err
+			}
+			// Enabled/UseAsExitNode có default:true nên giá trị false bị bỏ qua khi Create
+			// (và Create ghi đè struct bằng giá trị default), cần update lại từ snapshot
+			flags := models.Peer{ID: peer.ID, Enabled: sp.Enabled, UseAsExitNode: sp.UseAsExitNode}
+			if err := tx.Model(&flags).Select("enabled", "use_as_exit_node").Updates(&flags).Error; err != nil {
+				return err
+			}
+		}
+		for _, pf := range snap.PortForwards {
+			if err := create(fmt.Sprintf("port forward %d", pf.PublicPort), &pf); err != nil {
+				return err
+			}
+		}
+		for _, ep := range snap.Endpoints {
+			endpoint := models.Endpoint{ID: ep.ID, NetworkID: ep.NetworkID, PeerID: ep.PeerID, DomainID: ep.DomainID, Subdomain: ep.Subdomain, CreatedAt: ep.CreatedAt, UpdatedAt: ep.UpdatedAt}
+			if err := create("endpoint "+ep.Subdomain, &endpoint); err != nil {
+				return err
+			}
+		}
+		for _, r := range snap.Reservations {
+			if err := create("reservation "+r.StartIP, &r); err != nil {
+				return err
+			}
+		}
+		for _, r := range snap.ACLRules {
+			if err := create(fmt.Sprintf("access control rule %d", r.ID), &r); err != nil {
+				return err
+			}
+		}
+		for _, s := range snap.Settings {
+			if err := create("setting "+s.Key, &models.Setting{Key: s.Key, Value: s.Value}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
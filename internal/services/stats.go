@@ -0,0 +1,152 @@
+package services
+
+import (
+	"log"
+	"sync"
+	"time"
+	"wiretify/internal/database"
+	"wiretify/internal/models"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const statsCompactInterval = time.Hour
+
+// StatsSampler ghi lịch sử lưu lượng và handshake của từng peer vào bảng peer_samples:
+// sample theo phút được giữ trong rawRetention rồi gộp thành sample theo giờ,
+// sample theo giờ bị xoá sau retention.
+type StatsSampler struct {
+	rawRetention time.Duration
+	retention    time.Duration
+
+	mu          sync.Mutex
+	last        map[string][2]int64 // public key -> bộ đếm rx/tx kernel ở lần poll trước
+	lastCompact time.Time
+}
+
+func NewStatsSampler(rawRetention, retention time.Duration) *StatsSampler {
+	return &StatsSampler{
+		rawRetention: rawRetention,
+		retention:    retention,
+		last:         make(map[string][2]int64),
+	}
+}
+
+// RawRetention là khoảng thời gian còn giữ sample theo phút
+func (s *StatsSampler) RawRetention() time.Duration {
+	return s.rawRetention
+}
+
+// Observe là DeviceObserver, được DeviceMonitor gọi sau mỗi lần poll
+func (s *StatsSampler) Observe(now time.Time, devicePeers map[string]wgtypes.Peer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var peers []models.Peer
+	if err := database.DB.Select("id", "public_key").Find(&peers).Error; err != nil {
+		log.Printf("Warning: stats sampler failed to load peers: %v", err)
+		return
+	}
+
+	bucket := now.UTC().Truncate(time.Minute)
+	seen := make(map[string]bool, len(peers))
+	for _, p := range peers {
+		wgp, ok := devicePeers[p.PublicKey]
+		if !ok {
+			continue
+		}
+		seen[p.PublicKey] = true
+
+		prev, known := s.last[p.PublicKey]
+		s.last[p.PublicKey] = [2]int64{wgp.ReceiveBytes, wgp.TransmitBytes}
+		if !known {
+			// Lần đầu thấy peer: chỉ lấy mốc, chưa có delta
+			continue
+		}
+
+		sample := models.PeerSample{
+			PeerID:     p.ID,
+			Resolution: models.SampleResolutionMinute,
+			Bucket:     bucket,
+			RxBytes:    counterDelta(prev[0], wgp.ReceiveBytes),
+			TxBytes:    counterDelta(prev[1], wgp.TransmitBytes),
+		}
+		if !wgp.LastHandshakeTime.IsZero() {
+			hs := wgp.LastHandshakeTime.UTC()
+			sample.LastHandshake = &hs
+		}
+		if sample.RxBytes == 0 && sample.TxBytes == 0 && sample.LastHandshake == nil {
+			continue
+		}
+		if err := addSample(database.DB, sample); err != nil {
+			log.Printf("Warning: failed to store stats sample for peer %d: %v", p.ID, err)
+		}
+	}
+
+	for key := range s.last {
+		if !seen[key] {
+			delete(s.last, key)
+		}
+	}
+
+	if now.Sub(s.lastCompact) >= statsCompactInterval {
+		s.lastCompact = now
+		if err := s.compact(now); err != nil {
+			log.Printf("Warning: failed to compact stats samples: %v", err)
+		}
+	}
+}
+
+// compact gộp sample theo phút đã quá rawRetention thành sample theo giờ và xoá sample quá retention
+func (s *StatsSampler) compact(now time.Time) error {
+	rawCutoff := now.UTC().Add(-s.rawRetention).Truncate(time.Hour)
+	return database.DB.Transaction(func(tx *gorm.DB) error {
+		var raw []models.PeerSample
+		if err := tx.Where("resolution = ? AND bucket < ?", models.SampleResolutionMinute, rawCutoff).Find(&raw).Error; err != nil {
+			return err
+		}
+
+		type key struct {
+			peerID uint
+			bucket time.Time
+		}
+		hourly := make(map[key]*models.PeerSample)
+		for _, r := range raw {
+			k := key{r.PeerID, r.Bucket.UTC().Truncate(time.Hour)}
+			h, ok := hourly[k]
+			if !ok {
+				h = &models.PeerSample{PeerID: r.PeerID, Resolution: models.SampleResolutionHour, Bucket: k.bucket}
+				hourly[k] = h
+			}
+			h.RxBytes += r.RxBytes
+			h.TxBytes += r.TxBytes
+			if r.LastHandshake != nil && (h.LastHandshake == nil || r.LastHandshake.After(*h.LastHandshake)) {
+				h.LastHandshake = r.LastHandshake
+			}
+		}
+		for _, h := range hourly {
+			if err := addSample(tx, *h); err != nil {
+				return err
+			}
+		}
+
+		if err := tx.Where("resolution = ? AND bucket < ?", models.SampleResolutionMinute, rawCutoff).Delete(&models.PeerSample{}).Error; err != nil {
+			return err
+		}
+		return tx.Where("bucket < ?", now.UTC().Add(-s.retention)).Delete(&models.PeerSample{}).Error
+	})
+}
+
+// addSample cộng dồn sample vào bucket đã có (hoặc tạo mới)
+func addSample(db *gorm.DB, sample models.PeerSample) error {
+	return db.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "peer_id"}, {Name: "resolution"}, {Name: "bucket"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{
+			"rx_bytes":       gorm.Expr("rx_bytes + ?", sample.RxBytes),
+			"tx_bytes":       gorm.Expr("tx_bytes + ?", sample.TxBytes),
+			"last_handshake": gorm.Expr("COALESCE(excluded.last_handshake, last_handshake)"),
+		}),
+	}).Create(&sample).Error
+}
@@ -0,0 +1,190 @@
+package services
+
+import (
+	"fmt"
+	"log"
+	"time"
+	"wiretify/internal/models"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// UsageService cộng dồn lưu lượng của từng peer vào DB từ bộ đếm kernel,
+// reset theo chu kỳ và tự động disable peer vượt quota.
+type UsageService struct {
+	peerSvc *PeerService
+}
+
+func NewUsageService(peerSvc *PeerService) *UsageService {
+	return &UsageService{peerSvc: peerSvc}
+}
+
+// Observe là DeviceObserver, được DeviceMonitor gọi sau mỗi lần poll.
+// Usage được đọc và ghi trong transaction của PeerService để không ghi đè quota hoặc usage
+// vừa được đổi qua API, và việc tắt/bật peer theo quota nằm trong cùng transaction.
+func (s *UsageService) Observe(now time.Time, devicePeers map[string]wgtypes.Peer) {
+	err := s.peerSvc.change(func(ch *peerChange) error {
+		var peers []models.Peer
+		if err := ch.tx.Find(&peers).Error; err != nil {
+			return err
+		}
+
+		for i := range peers {
+			p := &peers[i]
+			updates := map[string]interface{}{}
+
+			// Bộ đếm kernel reset về 0 khi peer bị gỡ khỏi device hoặc interface bị tạo lại
+			var rx, tx int64
+			if wgp, ok := devicePeers[p.PublicKey]; ok {
+				rx, tx = wgp.ReceiveBytes, wgp.TransmitBytes
+			}
+			if rx != p.LastKernelRx || tx != p.LastKernelTx {
+				p.UsageRxBytes += counterDelta(p.LastKernelRx, rx)
+				p.UsageTxBytes += counterDelta(p.LastKernelTx, tx)
+				updates["usage_rx_bytes"] = p.UsageRxBytes
+				updates["usage_tx_bytes"] = p.UsageTxBytes
+				updates["last_kernel_rx"] = rx
+				updates["last_kernel_tx"] = tx
+			}
+
+			// Hết chu kỳ: reset usage và bật lại peer nếu nó bị tắt do quota
+			reenable := false
+			if end := p.UsagePeriodEnd(); !end.IsZero() && !now.Before(end) {
+				start := nextPeriodStart(*p, now)
+				p.UsageRxBytes, p.UsageTxBytes = 0, 0
+				updates["usage_rx_bytes"] = int64(0)
+				updates["usage_tx_bytes"] = int64(0)
+				updates["usage_period_start"] = start
+				peerID := p.ID
+				ch.afterCommit(func() {
+					RecordPeerEvent(peerID, models.PeerEventQuotaReset, fmt.Sprintf("Usage period reset, new period starts %s", start.Format(time.RFC3339)))
+				})
+				reenable = !p.Enabled && p.DisabledReason == models.DisabledReasonQuota
+			}
+
+			if len(updates) > 0 {
+				if err := ch.tx.Model(p).UpdateColumns(updates).Error; err != nil {
+					return fmt.Errorf("failed to store usage of peer %s: %v", p.Name, err)
+				}
+			}
+
+			used := p.UsageRxBytes + p.UsageTxBytes
+			switch {
+			case reenable:
+				if err := s.peerSvc.setEnabled(ch, p, true, ""); err != nil {
+					return err
+				}
+			case p.Enabled && p.QuotaBytes > 0 && used >= p.QuotaBytes:
+				msg := fmt.Sprintf("Quota exceeded (%d of %d bytes used), peer disabled", used, p.QuotaBytes)
+				log.Printf("Peer %s: %s", p.Name, msg)
+				peerID := p.ID
+				ch.afterCommit(func() { RecordPeerEvent(peerID, models.PeerEventQuotaExceeded, msg) })
+				if err := s.peerSvc.setEnabled(ch, p, false, models.DisabledReasonQuota); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("Warning: usage accounting failed: %v", err)
+	}
+}
+
+// SetQuota đổi quota và chu kỳ reset của peer trong transaction. Đổi chu kỳ thì bắt đầu đếm lại từ chu kỳ hiện tại;
+// peer đang bị tắt do vượt quota được bật lại nếu usage đã nằm dưới quota mới.
+func (s *PeerService) SetQuota(peerID uint, quotaBytes int64, period string, periodDays int) (*models.Peer, error) {
+	var peer models.Peer
+	err := s.change(func(ch *peerChange) error {
+		if err := ch.tx.First(&peer, peerID).Error; err != nil {
+			return err
+		}
+
+		updates := map[string]interface{}{
+			"quota_bytes":       quotaBytes,
+			"quota_period":      period,
+			"quota_period_days": periodDays,
+		}
+		if period != peer.QuotaPeriod || periodDays != peer.QuotaPeriodDays || peer.UsagePeriodStart == nil {
+			var start *time.Time
+			if period != "" {
+				t := CurrentPeriodStart(period, time.Now())
+				start = &t
+			}
+			updates["usage_period_start"] = start
+		}
+		if err := ch.tx.Model(&peer).Updates(updates).Error; err != nil {
+			return err
+		}
+		if err := ch.tx.First(&peer, peerID).Error; err != nil {
+			return err
+		}
+
+		if !peer.Enabled && peer.DisabledReason == models.DisabledReasonQuota && (peer.QuotaBytes == 0 || peer.UsageBytes < peer.QuotaBytes) {
+			return s.setEnabled(ch, &peer, true, "")
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &peer, nil
+}
+
+// ResetUsage đặt lại usage của chu kỳ hiện tại về 0 và bật lại peer nếu nó bị tắt do quota
+func (s *PeerService) ResetUsage(peerID uint) (*models.Peer, error) {
+	var peer models.Peer
+	err := s.change(func(ch *peerChange) error {
+		if err := ch.tx.First(&peer, peerID).Error; err != nil {
+			return err
+		}
+		if err := ch.tx.Model(&peer).UpdateColumns(map[string]interface{}{
+			"usage_rx_bytes": 0,
+			"usage_tx_bytes": 0,
+		}).Error; err != nil {
+			return err
+		}
+		if err := ch.tx.First(&peer, peerID).Error; err != nil {
+			return err
+		}
+		ch.afterCommit(func() { RecordPeerEvent(peerID, models.PeerEventQuotaReset, "Usage reset manually") })
+
+		if !peer.Enabled && peer.DisabledReason == models.DisabledReasonQuota {
+			return s.setEnabled(ch, &peer, true, "")
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &peer, nil
+}
+
+// counterDelta tính lượng tăng của bộ đếm kernel; nếu bộ đếm nhỏ đi thì kernel đã reset
+func counterDelta(last, current int64) int64 {
+	if current >= last {
+		return current - last
+	}
+	return current
+}
+
+// nextPeriodStart trả về thời điểm bắt đầu chu kỳ chứa now
+func nextPeriodStart(p models.Peer, now time.Time) time.Time {
+	start := *p.UsagePeriodStart
+	for {
+		p.UsagePeriodStart = &start
+		end := p.UsagePeriodEnd()
+		if end.IsZero() || end.After(now) {
+			return start
+		}
+		start = end
+	}
+}
+
+// CurrentPeriodStart trả về thời điểm bắt đầu chu kỳ mới cho một quota vừa được cấu hình
+func CurrentPeriodStart(period string, now time.Time) time.Time {
+	if period == models.QuotaPeriodMonthly {
+		return time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+	}
+	return now
+}
@@ -0,0 +1,188 @@
+package services
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// WGQuickInterface là section [Interface] của một file cấu hình wg-quick
+type WGQuickInterface struct {
+	PrivateKey string
+	Address    []string
+	ListenPort int
+	DNS        []string
+	MTU        int
+	PostUp     []string
+	PostDown   []string
+}
+
+// WGQuickPeer là một section [Peer]; Name lấy từ comment ngay trước section (vd. "# laptop")
+type WGQuickPeer struct {
+	Name                string
+	PublicKey           string
+	PresharedKey        string
+	AllowedIPs          []string
+	Endpoint            string
+	PersistentKeepalive int
+	Line                int // Dòng bắt đầu section, dùng khi báo lỗi
+}
+
+// WGQuickConfig là nội dung đã parse của một file wg-quick (vd. /etc/wireguard/wg0.conf)
+type WGQuickConfig struct {
+	Interface WGQuickInterface
+	Peers     []WGQuickPeer
+}
+
+// ParseWGQuick đọc cấu hình wg-quick dạng INI
+func ParseWGQuick(r io.Reader) (*WGQuickConfig, error) {
+	conf := &WGQuickConfig{}
+	scanner := bufio.NewScanner(r)
+
+	var (
+		section string
+		peer    *WGQuickPeer
+		comment string // comment gần nhất, dùng làm tên cho [Peer] tiếp theo
+		lineNo  int
+	)
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			if name := peerNameFromComment(line); name != "" {
+				if peer != nil && peer.Name == "" && peer.PublicKey == "" {
+					peer.Name = name
+				} else {
+					comment = name
+				}
+			}
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section = strings.ToLower(strings.TrimSpace(line[1 : len(line)-1]))
+			switch section {
+			case "interface":
+				peer = nil
+			case "peer":
+				conf.Peers = append(conf.Peers, WGQuickPeer{Name: comment, Line: lineNo})
+				peer = &conf.Peers[len(conf.Peers)-1]
+			default:
+				return nil, fmt.Errorf("line %d: unknown section [%s]", lineNo, section)
+			}
+			comment = ""
+			continue
+		}
+
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected key = value", lineNo)
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		// Comment cuối dòng
+		if i := strings.Index(value, "#"); i >= 0 {
+			value = value[:i]
+		}
+		value = strings.TrimSpace(value)
+
+		var err error
+		switch section {
+		case "interface":
+			err = conf.Interface.set(key, value)
+		case "peer":
+			err = peer.set(key, value)
+		default:
+			err = fmt.Errorf("%s outside of a section", key)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", lineNo, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return conf, nil
+}
+
+func (i *WGQuickInterface) set(key, value string) error {
+	var err error
+	switch key {
+	case "privatekey":
+		i.PrivateKey = value
+	case "address":
+		i.Address = append(i.Address, splitList(value)...)
+	case "listenport":
+		i.ListenPort, err = strconv.Atoi(value)
+	case "dns":
+		i.DNS = append(i.DNS, splitList(value)...)
+	case "mtu":
+		i.MTU, err = strconv.Atoi(value)
+	case "postup":
+		i.PostUp = append(i.PostUp, value)
+	case "postdown":
+		i.PostDown = append(i.PostDown, value)
+	}
+	// Các key khác của wg-quick (Table, PreUp, SaveConfig, FwMark...) không dùng tới
+	if err != nil {
+		return fmt.Errorf("invalid %s: %v", key, err)
+	}
+	return nil
+}
+
+func (p *WGQuickPeer) set(key, value string) error {
+	var err error
+	switch key {
+	case "publickey":
+		p.PublicKey = value
+	case "presharedkey":
+		p.PresharedKey = value
+	case "allowedips":
+		p.AllowedIPs = append(p.AllowedIPs, splitList(value)...)
+	case "endpoint":
+		p.Endpoint = value
+	case "persistentkeepalive":
+		if value != "off" {
+			p.PersistentKeepalive, err = strconv.Atoi(value)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("invalid %s: %v", key, err)
+	}
+	return nil
+}
+
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// peerNameFromComment lấy tên peer từ các kiểu comment thường gặp:
+// "# laptop", "# Name = laptop", "### begin laptop ###" (PiVPN)
+func peerNameFromComment(line string) string {
+	name := strings.Trim(line, "#; \t")
+	if key, value, ok := strings.Cut(name, "="); ok {
+		switch strings.ToLower(strings.TrimSpace(key)) {
+		case "name", "friendly_name", "friendlyname":
+			name = value
+		default:
+			return ""
+		}
+	}
+	if strings.HasPrefix(strings.ToLower(name), "end ") {
+		return ""
+	}
+	if strings.HasPrefix(strings.ToLower(name), "begin ") {
+		name = name[len("begin "):]
+	}
+	return strings.TrimSpace(name)
+}
@@ -4,7 +4,7 @@ import (
 	"fmt"
 	"log"
 	"net"
-	"os"
+	"time"
 	"wiretify/internal/config"
 	"wiretify/internal/models"
 
@@ -12,83 +12,227 @@ import (
 	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
 )
 
+// WGService điều khiển interface WireGuard của một mạng
 type WGService struct {
-	client *wgctrl.Client
-	cfg    *config.Config
+	client  *wgctrl.Client
+	cfg     *config.Config
+	network models.Network
 }
 
-func NewWGService(cfg *config.Config) (*WGService, error) {
+func NewWGService(cfg *config.Config, network models.Network) (*WGService, error) {
 	client, err := wgctrl.New()
 	if err != nil {
 		return nil, err
 	}
+	return &WGService{client: client, cfg: cfg, network: network}, nil
+}
 
-	if cfg.PrivateKey == "" {
-		priv, err := wgtypes.GeneratePrivateKey()
-		if err == nil {
-			cfg.PrivateKey = priv.String()
-			log.Printf("Generated initial Server Private Key.")
-			// Ghi vào file để dùng lại
-			f, err := os.OpenFile(".env", os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-			if err == nil {
-				defer f.Close()
-				f.WriteString(fmt.Sprintf("\nWG_PRIVATE_KEY=%s\n", cfg.PrivateKey))
-			} else {
-				log.Printf("Failed to write to .env: %v", err)
-			}
-		}
-	}
-
-	return &WGService{client: client, cfg: cfg}, nil
+// Network trả về cấu hình mạng mà service này quản lý
+func (s *WGService) Network() models.Network {
+	return s.network
 }
 
 func (s *WGService) Close() {
 	s.client.Close()
 }
 
-func (s *WGService) SyncPeers(peers []models.Peer) error {
-	privKey, err := wgtypes.ParseKey(s.cfg.PrivateKey)
+// SyncResult mô tả những thay đổi mà SyncPeers đã áp dụng lên kernel
+type SyncResult struct {
+	Added   []string `json:"added"`
+	Removed []string `json:"removed"`
+	Updated []string `json:"updated"`
+}
+
+// Changed reports whether the sync touched the device at all.
+func (r *SyncResult) Changed() bool {
+	return len(r.Added)+len(r.Removed)+len(r.Updated) > 0
+}
+
+func (r *SyncResult) String() string {
+	return fmt.Sprintf("%d added, %d removed, %d updated", len(r.Added), len(r.Removed), len(r.Updated))
+}
+
+// SyncPeers đối chiếu danh sách peer trong DB với trạng thái hiện tại của device
+// và chỉ áp dụng phần chênh lệch (thêm/xoá/cập nhật), không dùng ReplacePeers
+// để tránh reset session của các peer đang kết nối.
+func (s *WGService) SyncPeers(peers []models.Peer) (*SyncResult, error) {
+	// Đọc lại từ DB mỗi lần để không ghi đè key vừa được rotate bởi tiến trình khác
+	privKey, err := s.privateKey()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load server private key: %v", err)
+	}
+
+	device, err := s.client.Device(s.network.InterfaceName)
 	if err != nil {
-		return fmt.Errorf("failed to parse server private key: %v", err)
+		return nil, fmt.Errorf("failed to read device %s: %v", s.network.InterfaceName, err)
 	}
 
-	wgConfig := wgtypes.Config{
-		PrivateKey:   &privKey,
-		ListenPort:   &s.cfg.Port,
-		ReplacePeers: true, // Replace all peers with the ones from DB
-		Peers:        make([]wgtypes.PeerConfig, 0, len(peers)),
+	current := make(map[wgtypes.Key]wgtypes.Peer, len(device.Peers))
+	for _, p := range device.Peers {
+		current[p.PublicKey] = p
+	}
+
+	result := &SyncResult{Added: []string{}, Removed: []string{}, Updated: []string{}}
+	wgConfig := wgtypes.Config{}
+	if device.PrivateKey != privKey {
+		wgConfig.PrivateKey = &privKey
+	}
+	if device.ListenPort != s.network.ListenPort {
+		wgConfig.ListenPort = &s.network.ListenPort
 	}
 
+	now := time.Now()
+	desired := make(map[wgtypes.Key]bool, len(peers))
 	for _, p := range peers {
+		if !p.Enabled {
+			continue
+		}
+
 		pubKey, err := wgtypes.ParseKey(p.PublicKey)
 		if err != nil {
 			log.Printf("Skip invalid peer %s public key: %v", p.Name, err)
 			continue
 		}
 
-		_, ipNet, err := net.ParseCIDR(p.AllowedIPs)
+		allowedIPs, err := peerAllowedIPs(p)
 		if err != nil {
 			log.Printf("Skip invalid peer %s allowed IPs: %v", p.Name, err)
 			continue
 		}
 
-		wgConfig.Peers = append(wgConfig.Peers, wgtypes.PeerConfig{
-			PublicKey:         pubKey,
-			Remove:            !p.Enabled,
-			ReplaceAllowedIPs: true,
-			AllowedIPs:        []net.IPNet{*ipNet},
-		})
+		// Key rỗng (zero) nghĩa là không dùng PSK; cấu hình zero key sẽ xoá PSK trên device
+		var psk wgtypes.Key
+		if p.PresharedKey != "" {
+			psk, err = wgtypes.ParseKey(p.PresharedKey)
+			if err != nil {
+				log.Printf("Skip invalid peer %s preshared key: %v", p.Name, err)
+				continue
+			}
+		}
+
+		// Trong overlap window sau khi rotate key, một IP chỉ gắn được với một key:
+		// key cũ giữ AllowedIPs cho tới khi key mới handshake lần đầu
+		entries := []syncEntry{{key: pubKey, label: p.Name, allowedIPs: allowedIPs}}
+		if oldKey, ok := previousKey(p, now); ok {
+			old := syncEntry{key: oldKey, label: p.Name + " (previous key)"}
+			if current[pubKey].LastHandshakeTime.IsZero() {
+				old.allowedIPs, entries[0].allowedIPs = allowedIPs, nil
+			}
+			entries = append(entries, old)
+		}
+
+		for _, entry := range entries {
+			desired[entry.key] = true
+
+			existing, ok := current[entry.key]
+			switch {
+			case !ok:
+				result.Added = append(result.Added, entry.label)
+			case !sameIPNets(existing.AllowedIPs, entry.allowedIPs), existing.PresharedKey != psk:
+				result.Updated = append(result.Updated, entry.label)
+			default:
+				continue
+			}
+
+			wgConfig.Peers = append(wgConfig.Peers, wgtypes.PeerConfig{
+				PublicKey:         entry.key,
+				PresharedKey:      &psk,
+				ReplaceAllowedIPs: true,
+				AllowedIPs:        entry.allowedIPs,
+			})
+		}
 	}
 
-	if err := s.client.ConfigureDevice(s.cfg.InterfaceName, wgConfig); err != nil {
-		return fmt.Errorf("failed to configure device %s: %v", s.cfg.InterfaceName, err)
+	// Peer có trên device nhưng không còn (hoặc bị disable) trong DB thì gỡ ra
+	names := make(map[string]string, len(peers))
+	for _, p := range peers {
+		names[p.PublicKey] = p.Name
+		if p.PreviousPublicKey != "" {
+			names[p.PreviousPublicKey] = p.Name + " (previous key)"
+		}
 	}
+	for key := range current {
+		if desired[key] {
+			continue
+		}
+		label := names[key.String()]
+		if label == "" {
+			label = key.String()
+		}
+		result.Removed = append(result.Removed, label)
+		wgConfig.Peers = append(wgConfig.Peers, wgtypes.PeerConfig{PublicKey: key, Remove: true})
+	}
+
+	if len(wgConfig.Peers) == 0 && wgConfig.PrivateKey == nil && wgConfig.ListenPort == nil {
+		return result, nil
+	}
+
+	if err := s.client.ConfigureDevice(s.network.InterfaceName, wgConfig); err != nil {
+		return nil, fmt.Errorf("failed to configure device %s: %v", s.network.InterfaceName, err)
+	}
+
+	log.Printf("Synchronized peers to %s: %s", s.network.InterfaceName, result)
+	return result, nil
+}
+
+// syncEntry là một key cần có trên device cùng AllowedIPs của nó (key cũ trong overlap window không có AllowedIPs)
+type syncEntry struct {
+	key        wgtypes.Key
+	label      string
+	allowedIPs []net.IPNet
+}
 
-	log.Printf("Synchronized %d peers to %s", len(peers), s.cfg.InterfaceName)
-	return nil
+// previousKey trả về key cũ của peer nếu vẫn còn trong overlap window
+func previousKey(p models.Peer, now time.Time) (wgtypes.Key, bool) {
+	if p.PreviousPublicKey == "" || p.PreviousKeyExpiresAt == nil || !now.Before(*p.PreviousKeyExpiresAt) {
+		return wgtypes.Key{}, false
+	}
+	key, err := wgtypes.ParseKey(p.PreviousPublicKey)
+	if err != nil {
+		return wgtypes.Key{}, false
+	}
+	return key, true
+}
+
+// sameIPNets so sánh hai danh sách CIDR không phụ thuộc thứ tự
+func sameIPNets(a, b []net.IPNet) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	set := make(map[string]int, len(a))
+	for _, n := range a {
+		set[n.String()]++
+	}
+	for _, n := range b {
+		if set[n.String()] == 0 {
+			return false
+		}
+		set[n.String()]--
+	}
+	return true
 }
 
-func (s *WGService) GenerateKeyPair() (string, string, error) {
+// peerAllowedIPs trả về các dải IP mà server định tuyến tới peer
+// (IPv4 /32, IPv6 /128 nếu có và các subnet LAN phía sau peer)
+func peerAllowedIPs(p models.Peer) ([]net.IPNet, error) {
+	cidrs := []string{p.AllowedIPs}
+	if p.AllowedIPs6 != "" {
+		cidrs = append(cidrs, p.AllowedIPs6)
+	}
+	cidrs = append(cidrs, p.RoutedSubnets...)
+
+	ipNets := make([]net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, err
+		}
+		ipNets = append(ipNets, *ipNet)
+	}
+	return ipNets, nil
+}
+
+func GenerateKeyPair() (string, string, error) {
 	priv, err := wgtypes.GeneratePrivateKey()
 	if err != nil {
 		return "", "", err
@@ -96,8 +240,17 @@ func (s *WGService) GenerateKeyPair() (string, string, error) {
 	return priv.String(), priv.PublicKey().String(), nil
 }
 
+// GeneratePresharedKey sinh một PSK mới cho peer
+func GeneratePresharedKey() (string, error) {
+	key, err := wgtypes.GenerateKey()
+	if err != nil {
+		return "", err
+	}
+	return key.String(), nil
+}
+
 func (s *WGService) GetServerConfig() (string, string, int) {
-	privKey, err := wgtypes.ParseKey(s.cfg.PrivateKey)
+	privKey, err := s.privateKey()
 	var pubKey string
 	if err == nil {
 		pubKey = privKey.PublicKey().String()
@@ -105,19 +258,34 @@ func (s *WGService) GetServerConfig() (string, string, int) {
 		// Trả về tạm thời nếu server chưa sinh key
 		pubKey = "SERVER_PUBLIC_KEY_NOT_SET"
 	}
-	return pubKey, s.cfg.ServerEndpoint, s.cfg.Port
+	endpoint := s.network.Endpoint
+	if endpoint == "" {
+		endpoint = s.cfg.ServerEndpoint
+	}
+	return pubKey, endpoint, s.network.ListenPort
 }
 
 func (s *WGService) GetServerAddress() string {
-	return s.cfg.Address
+	return s.network.Address
+}
+
+// GetServerAddress6 returns the IPv6 interface address, or "" when dual-stack is disabled.
+func (s *WGService) GetServerAddress6() string {
+	return s.network.Address6
+}
+
+// IsConnected là quy tắc chung để coi một peer đang kết nối:
+// handshake gần nhất nằm trong khoảng HANDSHAKE_TIMEOUT
+func IsConnected(lastHandshake, now time.Time, timeout time.Duration) bool {
+	return !lastHandshake.IsZero() && now.Sub(lastHandshake) < timeout
 }
 
 func (s *WGService) GetDevicePeers() (map[string]wgtypes.Peer, error) {
-	device, err := s.client.Device(s.cfg.InterfaceName)
+	device, err := s.client.Device(s.network.InterfaceName)
 	if err != nil {
 		return nil, err
 	}
-	
+
 	peerMap := make(map[string]wgtypes.Peer)
 	for _, p := range device.Peers {
 		peerMap[p.PublicKey.String()] = p
@@ -2,7 +2,7 @@
 # Script to build and run Wiretify in development mode
 
 echo "Building Wiretify..."
-/home/accnet/local-go/bin/go build -o wiretify cmd/server/main.go
+/home/accnet/local-go/bin/go build -o wiretify ./cmd/server
 
 if [ $? -eq 0 ]; then
     echo "Build successful. Starting server with sudo..."